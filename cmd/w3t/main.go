@@ -2,19 +2,87 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/pion/logging"
 	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/signal"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/stun"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/turn"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
 	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webui"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "probe":
+			os.Exit(probe(os.Args[2:]))
+		case "manual":
+			os.Exit(manual(os.Args[2:]))
+		}
+	}
+
 	// read cmd flags
 	live := flag.Bool("live", false, "use live webui")
 	addr := flag.String("addr", ":3000", "a web server address")
+	stunServers := flag.String("stun-servers", stun.DefaultServer, "a comma-separated list of RFC5780 STUN servers for NAT tests")
+	stunServer := flag.String("stun-server", "", "two comma-separated IPs to run an RFC5780 STUN server on (i.e. 127.0.0.1,127.0.0.2)")
+	stunServerPorts := flag.String("stun-server-ports", "3478,3479", "two comma-separated STUN server ports")
+	turnAddr := flag.String("turn-addr", "", "a TURN server UDP/TCP address (i.e. :3478), disabled if empty")
+	turnIP := flag.String("turn-ip", "", "a public IP of the TURN server relay")
+	turnRealm := flag.String("turn-realm", "w3t", "a TURN server realm")
+	turnSecret := flag.String("turn-secret", "", "a TURN REST API shared secret (random if empty)")
+	turnTTL := flag.Duration("turn-ttl", time.Hour, "a lifetime of the TURN session credentials")
+	udpPort := flag.Int("udp-port", 0, "a single UDP port shared by all WebRTC sessions, disabled if 0")
+	tcpPort := flag.Int("tcp-port", 0, "a single ICE-TCP port shared by all WebRTC sessions, disabled if 0")
+	mediaDir := flag.String("media-dir", "", "a directory of the IVF (VP8/VP9/AV1) and Ogg/Opus files the sessions may play, disabled if empty")
+	recordDir := flag.String("record-dir", "", "a directory to save the incoming tracks of the sessions into, disabled if empty")
+	grace := flag.Duration("session-grace", 30*time.Second, "a time a session waits for the browser to reconnect")
 	flag.Parse()
 
+	if *stunServer != "" {
+		s, err := newStunServer(*stunServer, *stunServerPorts)
+		if err != nil {
+			log.Fatalf("stun server fail, %v", err)
+		}
+		defer func() { _ = s.Close() }()
+		s.Start()
+	}
+
+	var relay *turn.Server
+	if *turnAddr != "" {
+		s, err := turn.NewServer(turn.Config{
+			Addr:     *turnAddr,
+			PublicIP: *turnIP,
+			Realm:    *turnRealm,
+			Secret:   *turnSecret,
+			TTL:      *turnTTL,
+		}, logging.NewDefaultLoggerFactory())
+		if err != nil {
+			log.Fatalf("turn server fail, %v", err)
+		}
+		defer func() { _ = s.Close() }()
+		log.Printf("TURN server is listening on %s", s)
+		relay = s
+	}
+
+	var shared *webrtc.Mux
+	if *udpPort > 0 || *tcpPort > 0 {
+		m, err := webrtc.NewMux(*udpPort, *tcpPort, logging.NewDefaultLoggerFactory())
+		if err != nil {
+			log.Fatalf("mux fail, %v", err)
+		}
+		defer func() { _ = m.Close() }()
+		log.Printf("WebRTC sessions share %s", m)
+		shared = m
+	}
+
 	index, err := webui.Index(*live)
 	if err != nil {
 		log.Fatalf("web content fail, %v", err)
@@ -22,10 +90,39 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle("/", index)
-	mux.Handle("/websocket", signal.Handler())
+	if *recordDir != "" {
+		mux.Handle("/"+signal.RecordingsPath, signal.Recordings(*recordDir))
+	}
+	mux.Handle("/websocket", signal.Handler(signal.Config{
+		StunServers: strings.Split(*stunServers, ","),
+		Turn:        relay,
+		Mux:         shared,
+		Grace:       *grace,
+		MediaDir:    *mediaDir,
+		RecordDir:   *recordDir,
+	}))
 
 	log.Printf("Listening on %s...", *addr)
 	if err = http.ListenAndServe(*addr, mux); err != nil {
 		log.Fatal(err)
 	}
 }
+
+func newStunServer(ips, ports string) (*stun.Server, error) {
+	var ip [2]string
+	var port [2]int
+	a, b, _ := strings.Cut(ips, ",")
+	ip[0], ip[1] = strings.TrimSpace(a), strings.TrimSpace(b)
+	p := strings.Split(ports, ",")
+	if len(p) != 2 {
+		return nil, fmt.Errorf("needs two STUN server ports, got [%v]", ports)
+	}
+	for i := range p {
+		v, err := strconv.Atoi(strings.TrimSpace(p[i]))
+		if err != nil {
+			return nil, err
+		}
+		port[i] = v
+	}
+	return stun.NewServer(ip, port, logging.NewDefaultLeveledLoggerForScope("stun", logging.LogLevelInfo, os.Stdout))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/signal"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
+)
+
+// manualLinger is the time the peer stays after a passed test.
+const manualLinger = time.Second
+
+// manual runs the same peer as the server without any signaling,
+// the offer and the answer are copied and pasted by the user
+// (i.e. into the manual box of the web page or another w3t).
+// The log goes into stderr and the SDP into stdout.
+func manual(args []string) int {
+	fs := flag.NewFlagSet("manual", flag.ExitOnError)
+	answer := fs.Bool("answer", false, "take an offer and make the answer instead of the offer")
+	iceServers := fs.String("ice-servers", "", "STUN/TURN servers, comma-separated URLs or a JSON list")
+	logLevel := fs.String("log-level", "2", "a log level of the peer (0-5)")
+	opts := fs.String("opts", "", "peer options as a URL query, same as the server session options (i.e. ice_tcp_only=true)")
+	timeout := fs.Duration("timeout", 30*time.Second, "a time limit for the connection after the remote SDP")
+	_ = fs.Parse(args)
+
+	c := console{start: time.Now(), w: os.Stderr}
+
+	q, err := url.ParseQuery(*opts)
+	if err != nil {
+		c.log("sys", "error: bad opts, %v", err)
+		return probeError
+	}
+	if *iceServers != "" {
+		q.Set("ice_servers", *iceServers)
+	}
+	conf, err := signal.PeerConfig(q)
+	if err != nil {
+		c.log("sys", "error: %v", err)
+		return probeError
+	}
+	conf.Logger = webrtc.NewLoggerFactory(*logLevel, c.log)
+
+	peer, err := webrtc.NewPeerConnection(conf)
+	if err != nil {
+		c.log("rtc", "fail: %v", err)
+		return probeError
+	}
+	defer func() { _ = peer.Close() }()
+
+	result := make(chan string, 1)
+	var once sync.Once
+	done := func(reason string) { once.Do(func() { result <- reason }) }
+
+	peer.OnIceConnectionStateChange(func(s webrtc.ICEConnectionState) { c.log("ice", "→ %v", s) })
+	peer.OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		c.log("ice", "selected pair %v <-> %v", pair.Local, pair.Remote)
+	})
+	peer.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		c.log("rtc", "→ %v", s)
+		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateClosed {
+			done("peer connection " + s.String())
+		}
+	})
+	// both sides greet each other, the test passes with the first greeting
+	test := func(d *webrtc.DataChannel) {
+		d.OnOpen(func() {
+			c.log("dc", "open")
+			host, _ := os.Hostname()
+			_ = d.SendText("hello from w3t " + host)
+		})
+		d.OnMessage(func(data []byte) {
+			c.log("dc", "← %s", data)
+			done("")
+		})
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	gathered := peer.GatheringComplete()
+	if *answer {
+		peer.OnDataChannel(test)
+		offer, err := readSDP(in, "offer")
+		if err != nil {
+			c.log("sig", "error: %v", err)
+			return probeError
+		}
+		if err = peer.SetRemoteSDP(offer); err != nil {
+			c.log("rtc", "fail: %v", err)
+			return probeError
+		}
+		if _, err = peer.CreateAnswer(); err != nil {
+			c.log("rtc", "fail: %v", err)
+			return probeError
+		}
+	} else {
+		dc, err := peer.CreateDataChannel("data", nil)
+		if err != nil {
+			c.log("rtc", "fail: %v", err)
+			return probeError
+		}
+		test(dc)
+		if _, err = peer.CreateOffer(); err != nil {
+			c.log("rtc", "fail: %v", err)
+			return probeError
+		}
+	}
+
+	select {
+	case <-gathered:
+	case <-time.After(*timeout):
+		c.log("ice", "gathering is too long, some candidates are missing")
+	}
+	local, err := webrtc.EncodeSDP(*peer.LocalSDP())
+	if err != nil {
+		c.log("sig", "error: %v", err)
+		return probeError
+	}
+	fmt.Fprintf(os.Stderr, "Copy the %v:\n", peer.LocalSDP().Type)
+	fmt.Println(local)
+
+	if !*answer {
+		remote, err := readSDP(in, "answer")
+		if err != nil {
+			c.log("sig", "error: %v", err)
+			return probeError
+		}
+		if err = peer.SetRemoteSDP(remote); err != nil {
+			c.log("rtc", "fail: %v", err)
+			return probeError
+		}
+	}
+
+	start := time.Now()
+	var reason string
+	select {
+	case reason = <-result:
+	case <-time.After(*timeout):
+		reason = fmt.Sprintf("no data channel messages in %v", *timeout)
+	}
+	if reason != "" {
+		fmt.Printf("FAIL: %v\n", reason)
+		return probeFail
+	}
+	fmt.Printf("PASS: connected in %v\n", time.Since(start).Round(time.Millisecond))
+	// let the other side get its greeting before the connection is closed
+	time.Sleep(manualLinger)
+	return probePass
+}
+
+// readSDP reads the pasted session description of the type.
+func readSDP(in *bufio.Reader, typ string) (webrtc.SessionDescription, error) {
+	fmt.Fprintf(os.Stderr, "Paste the %v:\n", typ)
+	for {
+		line, err := in.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			sd, err := webrtc.DecodeSDP(line)
+			if err == nil && sd.Type.String() != typ {
+				err = fmt.Errorf("got %v instead of %v", sd.Type, typ)
+			}
+			return sd, err
+		}
+		if err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/api"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
+	"golang.org/x/net/websocket"
+)
+
+// probe exit codes
+const (
+	probePass  = 0
+	probeFail  = 1
+	probeError = 2
+)
+
+// probe connects to a running w3t server the same way the web UI does
+// and checks that a peer connection with the server can be established.
+func probe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	addr := fs.String("url", "ws://localhost:3000/websocket", "a w3t server websocket URL")
+	timeout := fs.Duration("timeout", 30*time.Second, "a time limit for the connection")
+	flip := fs.Bool("flip", false, "make the server do the offer")
+	iceServers := fs.String("ice-servers", "", "STUN/TURN servers of both peers, comma-separated URLs or a JSON list")
+	networkTypes := fs.String("network-types", "udp4,udp6,tcp4,tcp6", "comma-separated network types of the probe peer, TCP makes active ICE-TCP candidates")
+	logLevel := fs.String("log-level", "3", "a log level of both peers (0-5)")
+	hold := fs.Duration("hold", 0, "a time to keep the connection for the server data channel RTT measurement")
+	opts := fs.String("opts", "", "extra server session options as a URL query (i.e. ice_tcp_only=true&port=8443)")
+	_ = fs.Parse(args)
+
+	servers, err := webrtc.ParseICEServers(*iceServers)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return probeError
+	}
+
+	u, err := url.Parse(*addr)
+	if err != nil {
+		fmt.Printf("error: bad url, %v\n", err)
+		return probeError
+	}
+	q, err := url.ParseQuery(*opts)
+	if err != nil {
+		fmt.Printf("error: bad opts, %v\n", err)
+		return probeError
+	}
+	if !q.Has("log_level") {
+		q.Set("log_level", *logLevel)
+	}
+	if *flip {
+		q.Set("flip_offer_side", "true")
+	}
+	if len(servers) > 0 {
+		v, _ := json.Marshal(servers)
+		q.Set("ice_servers", string(v))
+	}
+	u.RawQuery = q.Encode()
+
+	origin := *u
+	origin.Scheme, origin.RawQuery = "http", ""
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+
+	p := prober{console: console{start: time.Now(), w: os.Stdout}, result: make(chan string, 1), closed: make(chan struct{})}
+	p.log("ws", "connect to %v", u)
+	conn, err := websocket.Dial(u.String(), "", origin.String())
+	if err != nil {
+		p.log("ws", "fail: %v", err)
+		return probeError
+	}
+	p.conn = conn
+
+	// the server sets up the session before it sends the session id
+	_ = conn.SetReadDeadline(time.Now().Add(*timeout))
+	added, err := p.setup()
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		_ = conn.Close()
+		return probeFail
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	peer, err := webrtc.NewPeerConnection(webrtc.Config{
+		IceServers:   append(servers, added...),
+		Logger:       webrtc.NewLoggerFactory(*logLevel, p.log),
+		NetworkTypes: list(*networkTypes),
+	})
+	if err != nil {
+		p.log("rtc", "fail: %v", err)
+		return probeError
+	}
+	p.peer = peer
+
+	peer.OnIceCandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		p.log("ice", "local %v", c)
+		p.send(api.NewIce(*c))
+	})
+	peer.OnIceConnectionStateChange(func(s webrtc.ICEConnectionState) { p.log("ice", "→ %v", s) })
+	peer.OnSignalingStateChange(func(s webrtc.SignalingState) { p.log("sig", "→ %v", s) })
+	peer.OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		p.log("ice", "selected pair %v <-> %v", pair.Local, pair.Remote)
+		p.mu.Lock()
+		p.pair = pair.String()
+		p.mu.Unlock()
+	})
+	peer.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		p.log("rtc", "→ %v", s)
+		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateClosed {
+			p.done("peer connection " + s.String())
+		}
+	})
+	// the data channel is open when the connection works end to end
+	channel := func(d *webrtc.DataChannel) {
+		d.OnOpen(func() { p.done("") })
+		d.OnMessage(func(data []byte) {
+			// the server measures its round-trip time
+			if rest, ok := strings.CutPrefix(string(data), "ping "); ok {
+				_ = d.SendText("pong " + rest)
+				return
+			}
+			p.log("dc", "%s", data)
+		})
+	}
+
+	go p.receive()
+
+	if *flip {
+		peer.OnDataChannel(channel)
+		p.send(api.NewWaitingOffer())
+	} else {
+		dc, err := peer.CreateDataChannel("data", nil)
+		if err != nil {
+			p.log("rtc", "fail: %v", err)
+			return probeError
+		}
+		channel(dc)
+		offer, err := peer.CreateOffer()
+		if err != nil {
+			p.log("rtc", "fail: %v", err)
+			return probeError
+		}
+		p.send(api.NewSDP(*offer, api.WebrtcOffer))
+	}
+
+	var reason string
+	select {
+	case reason = <-p.result:
+	case <-time.After(*timeout):
+		reason = fmt.Sprintf("no connection in %v", *timeout)
+	}
+	elapsed := time.Since(p.start).Round(time.Millisecond)
+	if reason == "" && *hold > 0 {
+		p.log("sys", "holding the connection for %v", *hold)
+		time.Sleep(*hold)
+	}
+	p.send(api.NewClose())
+	// the server logs the session summary before its close
+	select {
+	case <-p.closed:
+	case <-time.After(time.Second):
+	}
+	_ = peer.Close()
+	_ = conn.Close()
+
+	if reason != "" {
+		fmt.Printf("FAIL: %v\n", reason)
+		return probeFail
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Printf("PASS: connected in %v, selected pair %v\n", elapsed, p.pair)
+	return probePass
+}
+
+// list splits a comma-separated value skipping empty items,
+// as the server does with the session options.
+func list(v string) []string {
+	var items []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// console prints log lines with the time since the start.
+type console struct {
+	start time.Time
+	w     io.Writer
+}
+
+func (c console) log(tag string, format string, v ...any) string {
+	line := fmt.Sprintf("%8v %-4s %s", time.Since(c.start).Round(time.Millisecond), tag, fmt.Sprintf(format, v...))
+	_, _ = fmt.Fprintln(c.w, line)
+	return line
+}
+
+// prober is the probe side of the signaling.
+type prober struct {
+	console
+	conn   *websocket.Conn
+	peer   *webrtc.Peer
+	result chan string
+	// closed is closed on the first server close
+	closed    chan struct{}
+	once      sync.Once
+	closeOnce sync.Once
+
+	// mu guards the websocket writes and the selected pair
+	mu   sync.Mutex
+	pair string
+}
+
+// done reports the result, an empty reason means success.
+func (p *prober) done(reason string) {
+	p.once.Do(func() { p.result <- reason })
+}
+
+func (p *prober) send(m any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := websocket.JSON.Send(p.conn, m); err != nil {
+		p.log("ws", "err: %v", err)
+	}
+}
+
+// setup reads the server messages up to the session id and returns
+// the ICE servers the server has added, i.e. its TURN server,
+// so the probe tries the same relays as the web UI.
+func (p *prober) setup() ([]webrtc.ICEServer, error) {
+	var servers []webrtc.ICEServer
+	for {
+		var m api.Message
+		if err := websocket.JSON.Receive(p.conn, &m); err != nil {
+			return nil, fmt.Errorf("no session, %w", err)
+		}
+		p.handle(m)
+		switch m.T {
+		case api.MessageSession:
+			return servers, nil
+		case api.MessageIceServers:
+			var ices []webrtc.ICEServer
+			if err := json.Unmarshal(m.Payload, &ices); err != nil {
+				return nil, fmt.Errorf("bad ICE servers, %w", err)
+			}
+			servers = append(servers, ices...)
+		case api.WebrtcClose:
+			return nil, errors.New("the server has closed the session")
+		}
+	}
+}
+
+// receive handles the server messages until the websocket is closed.
+func (p *prober) receive() {
+	for {
+		var m api.Message
+		if err := websocket.JSON.Receive(p.conn, &m); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.log("ws", "closed: %v", err)
+			p.done("signaling has been closed")
+			return
+		}
+		p.handle(m)
+	}
+}
+
+// handle reacts to a server message like the web UI does.
+func (p *prober) handle(m api.Message) {
+	switch m.T {
+	case api.WebrtcAnswer, api.WebrtcOffer:
+		sdp, err := api.NewSessionDescription(m.Payload)
+		if err != nil {
+			p.log("rtc", "err: %v", err)
+			return
+		}
+		p.log("rtc", "← SDP %v", m.T)
+		if err = p.peer.SetRemoteSDP(sdp.SessionDescription); err != nil {
+			p.log("rtc", "err: %v", err)
+			p.done("bad remote SDP")
+			return
+		}
+		if m.T == api.WebrtcAnswer {
+			return
+		}
+		answer, err := p.peer.CreateAnswer()
+		if err != nil {
+			p.log("rtc", "err: %v", err)
+			p.done("no answer")
+			return
+		}
+		p.send(api.NewSDP(*answer, api.WebrtcAnswer))
+	case api.WebrtcIce:
+		c, err := api.NewIceCandidateInit(m.Payload)
+		if err != nil {
+			p.log("ice", "err: %v", err)
+			return
+		}
+		p.log("ice", "remote %v", c.Candidate)
+		if err = p.peer.AddIceCandidate(c); err != nil {
+			p.log("ice", "err: %v", err)
+		}
+	case api.MessageLog:
+		var l api.Log
+		if json.Unmarshal(m.Payload, &l) == nil {
+			p.log("← "+l.Tag, "%s", l.Text)
+		}
+	case api.MessageLogs:
+		var logs []api.Log
+		_ = json.Unmarshal(m.Payload, &logs)
+		for _, l := range logs {
+			p.log("← "+l.Tag, "%s", l.Text)
+		}
+	case api.WebrtcClose:
+		p.log("sig", "← close")
+		p.done("the server has closed the session")
+		p.closeOnce.Do(func() { close(p.closed) })
+	case api.MessageSession, api.MessageConfig, api.MessageIceServers,
+		api.MessageNat, api.MessageTurnCheck, api.MessageStats:
+		p.log("← "+strings.ToLower(string(m.T)), "%s", m.Payload)
+	default:
+		p.log("sig", "unknown server message [%v]", m.T)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/pion/webrtc/v4"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/stun"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/turn"
+	rtc "github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
 )
 
 const (
@@ -14,6 +17,17 @@ const (
 	WebrtcWaitingOffer MessageType = "WAITING_OFFER"
 	WebrtcClose        MessageType = "CLOSE"
 	MessageLog         MessageType = "LOG"
+	MessageLogs        MessageType = "LOGS"
+	MessageStats       MessageType = "STATS"
+	MessageNat         MessageType = "NAT"
+	MessageIceServers  MessageType = "ICE_SERVERS"
+	MessageTurnCheck   MessageType = "TURN_CHECK"
+	MessageConfig      MessageType = "CONFIG"
+	MessageSession     MessageType = "SESSION"
+	MessageRoom        MessageType = "ROOM"
+	MessageThroughput  MessageType = "THROUGHPUT"
+	MessageSize        MessageType = "MESSAGE_SIZE"
+	MessageRecording   MessageType = "RECORDING"
 )
 
 type (
@@ -24,6 +38,16 @@ type (
 	Close struct {
 		typed
 	}
+	// Config is the effective server peer configuration
+	Config struct {
+		typed
+		Payload rtc.Config `json:"p"`
+	}
+	// IceServers are additional ICE servers provided by the server
+	IceServers struct {
+		typed
+		Payload []webrtc.ICEServer `json:"p"`
+	}
 	Log struct {
 		Tag  string    `json:"tag"`
 		Time time.Time `json:"time"`
@@ -33,14 +57,72 @@ type (
 		typed
 		Payload Log `json:"p"`
 	}
+	// Logs is a batch of log lines
+	Logs struct {
+		typed
+		Payload []Log `json:"p"`
+	}
 	Message struct {
 		typed
 		Payload json.RawMessage `json:"p,omitempty"`
 	}
+	// Nat is the NAT behavior discovery verdict
+	Nat struct {
+		typed
+		Payload stun.Report `json:"p"`
+	}
 	MessageType        string
 	SessionDescription struct {
 		webrtc.SessionDescription
 	}
+	// Stats is a periodic server-side peer connection stats snapshot
+	Stats struct {
+		typed
+		Payload rtc.Stats `json:"p"`
+	}
+	// TurnCheck is a list of TURN allocation pre-flight checks
+	TurnCheck struct {
+		typed
+		Payload []turn.Check `json:"p"`
+	}
+	// Session identifies a server session for reconnects
+	Session struct {
+		typed
+		Payload struct {
+			ID string `json:"id"`
+		} `json:"p"`
+	}
+	// Room is the state of a room of two browsers
+	Room struct {
+		typed
+		Payload RoomState `json:"p"`
+	}
+	RoomState struct {
+		Code  string `json:"code"`
+		Peers int    `json:"peers"`
+		// Offer tells the browser to make the offer
+		Offer bool `json:"offer,omitempty"`
+	}
+	// ThroughputRequest asks for a data channel throughput test,
+	// where the direction is up, down or both from the client side
+	ThroughputRequest struct {
+		Duration  int    `json:"duration"`
+		Direction string `json:"direction"`
+	}
+	// MessageSizeRequest asks for a max message size test,
+	// where the direction is up, down or both from the client side
+	MessageSizeRequest struct {
+		Direction string `json:"direction"`
+	}
+	// Recording is a file of an incoming track saved by the server,
+	// where the URL is relative to the web page
+	Recording struct {
+		typed
+		Payload struct {
+			rtc.Recording
+			URL string `json:"url"`
+		} `json:"p"`
+	}
 	// SDP answer/offer
 	SDP struct {
 		typed
@@ -63,6 +145,18 @@ func NewIceCandidateInit(data []byte) (webrtc.ICECandidateInit, error) {
 	return candidate, err
 }
 
+func NewThroughputRequest(data []byte) (ThroughputRequest, error) {
+	var r ThroughputRequest
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
+func NewMessageSizeRequest(data []byte) (MessageSizeRequest, error) {
+	var r MessageSizeRequest
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
 func NewSDP(s webrtc.SessionDescription, t MessageType) SDP {
 	return SDP{typed: typed{t}, Payload: s}
 }
@@ -81,4 +175,32 @@ func NewLog(l Log) LogMessage {
 	}
 }
 
+func NewLogs(l []Log) Logs { return Logs{typed{MessageLogs}, l} }
+
+func NewStats(s rtc.Stats) Stats { return Stats{typed{MessageStats}, s} }
+
+func NewNat(r stun.Report) Nat { return Nat{typed{MessageNat}, r} }
+
+func NewIceServers(s []webrtc.ICEServer) IceServers { return IceServers{typed{MessageIceServers}, s} }
+
+func NewTurnCheck(c []turn.Check) TurnCheck { return TurnCheck{typed{MessageTurnCheck}, c} }
+
+func NewConfig(c rtc.Config) Config { return Config{typed{MessageConfig}, c} }
+
+func NewSession(id string) Session {
+	s := Session{typed: typed{MessageSession}}
+	s.Payload.ID = id
+	return s
+}
+
+func NewRecording(r rtc.Recording, url string) Recording {
+	m := Recording{typed: typed{MessageRecording}}
+	m.Payload.Recording, m.Payload.URL = r, url
+	return m
+}
+
+func NewRoom(r RoomState) Room { return Room{typed{MessageRoom}, r} }
+
+func NewWaitingOffer() Message { return Message{typed: typed{WebrtcWaitingOffer}} }
+
 func NewClose() Close { return Close{typed{WebrtcClose}} }
@@ -0,0 +1,203 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+// opusRate is the Opus granule position and RTP clock rate.
+const opusRate = 48000
+
+var (
+	ivfCodecs = map[string]string{
+		"VP80": webrtc.MimeTypeVP8,
+		"VP90": webrtc.MimeTypeVP9,
+		"AV01": webrtc.MimeTypeAV1,
+	}
+	fileTypes = []string{".ivf", ".ogg", ".opus"}
+)
+
+// File reads the frames of an IVF (VP8/VP9/AV1) or Ogg/Opus file
+// over and over again.
+type File struct {
+	// MimeType is the codec of the file.
+	MimeType string
+	// Loops is how many times the file has been read to the end.
+	Loops int
+
+	f     *os.File
+	reset func() error
+	next  func() ([]byte, time.Duration, error)
+}
+
+// Files returns the names of the files in the directory
+// that may be played.
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && slices.Contains(fileTypes, strings.ToLower(filepath.Ext(e.Name()))) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// OpenFile opens the named file of the root by its extension.
+func OpenFile(root *os.Root, name string) (*File, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	file := File{f: f}
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".ivf":
+		err = file.ivf()
+	case ".ogg", ".opus":
+		err = file.ogg()
+	default:
+		err = fmt.Errorf("unsupported file type %q", ext)
+	}
+	if err == nil {
+		err = file.reset()
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("no frames")
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	return &file, nil
+}
+
+// Next returns the next frame with its duration taken from the container timestamps.
+// It starts the file again at the end.
+func (f *File) Next() ([]byte, time.Duration, error) {
+	data, d, err := f.next()
+	if !errors.Is(err, io.EOF) {
+		return data, d, err
+	}
+	f.Loops++
+	if err = f.reset(); err != nil {
+		return nil, 0, err
+	}
+	data, d, err = f.next()
+	if errors.Is(err, io.EOF) {
+		return nil, 0, errors.New("no frames")
+	}
+	return data, d, err
+}
+
+// Close closes the file.
+func (f *File) Close() error { return f.f.Close() }
+
+// ivf reads the IVF frames one ahead, because a frame lasts until the next one.
+func (f *File) ivf() error {
+	var (
+		r        *ivfreader.IVFReader
+		h        *ivfreader.IVFFileHeader
+		frame    []byte
+		at, last time.Duration
+	)
+	open := func() (err error) {
+		if _, err = f.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r, h, err = ivfreader.NewWith(f.f)
+		return err
+	}
+	// read returns the next frame and its time, where ivfreader has already
+	// multiplied the pts with the inverted timebase (den/num)
+	read := func() ([]byte, time.Duration, error) {
+		data, fh, err := r.ParseNextFrame()
+		if err != nil {
+			return nil, 0, err
+		}
+		num, den := float64(h.TimebaseNumerator), float64(h.TimebaseDenominator)
+		return data, time.Duration(float64(fh.Timestamp) * num / den * num / den * float64(time.Second)), nil
+	}
+
+	if err := open(); err != nil {
+		return err
+	}
+	mime, ok := ivfCodecs[h.FourCC]
+	if !ok {
+		return fmt.Errorf("unsupported IVF codec %q", h.FourCC)
+	}
+	f.MimeType = mime
+
+	f.reset = func() (err error) {
+		if err = open(); err != nil {
+			return err
+		}
+		frame, at, err = read()
+		return err
+	}
+	f.next = func() ([]byte, time.Duration, error) {
+		if frame == nil {
+			return nil, 0, io.EOF
+		}
+		data, next, err := read()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+		out, d := frame, last
+		if data != nil && next > at {
+			d = next - at
+		}
+		frame, at, last = data, next, d
+		return out, d, nil
+	}
+	return nil
+}
+
+// ogg reads the Opus packets of the pages, where every page should hold one packet,
+// i.e. ffmpeg -page_duration 20000 or the recordings.
+// The granule gaps of the lost packets or DTX are kept as longer packets.
+func (f *File) ogg() error {
+	var (
+		r       *oggreader.OggReader
+		granule uint64
+	)
+	f.MimeType = webrtc.MimeTypeOpus
+	f.reset = func() (err error) {
+		if _, err = f.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r, _, err = oggreader.NewWith(f.f)
+		granule = 0
+		return err
+	}
+	f.next = func() ([]byte, time.Duration, error) {
+		for {
+			page, h, err := r.ParseNextPage()
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			if t, ok := h.HeaderType(page); len(page) == 0 || ok && t == oggreader.HeaderOpusTags {
+				continue
+			}
+			samples := h.GranulePosition - granule
+			granule = h.GranulePosition
+			return page, time.Duration(samples) * time.Second / opusRate, nil
+		}
+	}
+	return nil
+}
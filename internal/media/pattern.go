@@ -0,0 +1,79 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+var (
+	bars = []color.RGBA{
+		{235, 235, 235, 255}, {235, 235, 16, 255}, {16, 235, 235, 255}, {16, 235, 16, 255},
+		{235, 16, 235, 255}, {235, 16, 16, 255}, {16, 16, 235, 255},
+	}
+	black = color.RGBA{16, 16, 16, 255}
+	white = color.RGBA{235, 235, 235, 255}
+	gray  = color.RGBA{128, 128, 128, 255}
+
+	// digits is a 3x5 font, where the bits of a row are the pixels from the left
+	digits = [10][5]uint8{
+		{7, 5, 5, 5, 7}, {2, 6, 2, 2, 7}, {7, 1, 7, 4, 7}, {7, 1, 7, 1, 7}, {5, 5, 7, 1, 1},
+		{7, 4, 7, 1, 7}, {7, 4, 7, 5, 7}, {7, 1, 2, 2, 2}, {7, 5, 7, 5, 7}, {7, 5, 7, 1, 7},
+	}
+)
+
+// patternDigits is the number of the frame number digits.
+const patternDigits = 6
+
+// Pattern draws the test picture of the frame: color bars, the frame number
+// and a block moving a step each frame, so the freezes and drops are seen at once.
+// The edges are on the 8x8 grid, which the VP8 encoder keeps sharp.
+func Pattern(img *image.YCbCr, frame int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	grid := func(v int) int { return v / 8 * 8 }
+
+	top := grid(h * 2 / 5)
+	for i, c := range bars {
+		rect(img, image.Rect(grid(w*i/len(bars)), 0, grid(w*(i+1)/len(bars)), top), c)
+	}
+
+	bottom := grid(h - h/6)
+	rect(img, image.Rect(0, top, w, bottom), black)
+	scale := h / 24 / 4 * 4
+	number := fmt.Sprintf("%0*d", patternDigits, frame%1_000_000)
+	x0 := grid((w - patternDigits*4*scale) / 2)
+	y0 := grid((top + bottom - 5*scale) / 2)
+	for i, d := range number {
+		for row, bits := range digits[d-'0'] {
+			for col := 0; col < 3; col++ {
+				if bits>>(2-col)&1 == 0 {
+					continue
+				}
+				x, y := x0+(i*4+col)*scale, y0+row*scale
+				rect(img, image.Rect(x, y, x+scale, y+scale), white)
+			}
+		}
+	}
+
+	rect(img, image.Rect(0, bottom, w, h), gray)
+	step := grid(w / 40)
+	x := frame * step % w
+	rect(img, image.Rect(x, bottom, x+step*2, h), white)
+}
+
+// rect fills the rectangle with the color.
+func rect(img *image.YCbCr, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Rect)
+	y, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			img.Y[img.YOffset(px, py)] = y
+		}
+	}
+	for py := r.Min.Y; py < r.Max.Y; py += 2 {
+		for px := r.Min.X; px < r.Max.X; px += 2 {
+			i := img.COffset(px, py)
+			img.Cb[i], img.Cr[i] = cb, cr
+		}
+	}
+}
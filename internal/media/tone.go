@@ -0,0 +1,51 @@
+package media
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// PCMURate is the sample rate of G.711.
+	PCMURate = 8000
+	// beepFrequency and beepLength make a short beep at the start of every second.
+	beepFrequency = 1000
+	beepLength    = 100 * time.Millisecond
+	beepAmplitude = 8000
+)
+
+// Beep is a G.711 µ-law (PCMU) stream with a beep every second and silence between them,
+// so the lost audio is heard as broken beeps and the video frame numbers can be matched.
+type Beep struct {
+	n int
+}
+
+// Next returns the next samples of the duration.
+func (b *Beep) Next(d time.Duration) []byte {
+	samples := make([]byte, int(d*PCMURate/time.Second))
+	beep := int(beepLength * PCMURate / time.Second)
+	for i := range samples {
+		var v float64
+		if b.n%PCMURate < beep {
+			v = beepAmplitude * math.Sin(2*math.Pi*beepFrequency*float64(b.n)/PCMURate)
+		}
+		samples[i] = ulaw(int(v))
+		b.n++
+	}
+	return samples
+}
+
+// ulaw encodes a 16-bit linear sample with the G.711 µ-law.
+func ulaw(v int) byte {
+	const bias, limit = 0x84, 32635
+	sign := 0
+	if v < 0 {
+		v, sign = -v, 0x80
+	}
+	v = min(v, limit) + bias
+	exp := 7
+	for mask := 0x4000; v&mask == 0 && exp > 0; mask >>= 1 {
+		exp--
+	}
+	return ^byte(sign | exp<<4 | v>>(exp+3)&0x0f)
+}
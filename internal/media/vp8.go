@@ -0,0 +1,400 @@
+package media
+
+import (
+	"image"
+)
+
+// VP8 is a tiny intra-only VP8 encoder (RFC 6386) for synthetic pictures.
+// Every frame is a key frame where the macroblocks use DC prediction
+// and only DC coefficients, so each 4x4 luma and 8x8 chroma pixel block is flat.
+// That is enough for test patterns and large text without a real encoder.
+type VP8 struct {
+	width, height int
+	// mbw and mbh are the frame size in macroblocks
+	mbw, mbh int
+	// y, u and v are the decoder reconstruction used for the prediction
+	y, u, v []uint8
+
+	// the non-zero flags of the blocks to the left and above for the token contexts
+	leftY2  uint8
+	aboveY2 []uint8
+	leftUV  [4]uint8
+	aboveUV [][4]uint8
+}
+
+const (
+	// vp8Quant is the quantizer index, the lowest one gives the exact colors
+	vp8Quant = 0
+	// the dequantization factors of the index
+	vp8Y2DC = 8
+	vp8Y2AC = 8
+	vp8UVDC = 4
+)
+
+var (
+	// vp8Zigzag is the coefficient scan order
+	vp8Zigzag = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	// vp8Bands are the coefficient bands of the scan positions
+	vp8Bands = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// vp8Cat are the extra bit probabilities of the token categories 3-6
+	vp8Cat = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// the token planes
+const (
+	planeY1WithY2 = iota
+	planeY2
+	planeUV
+)
+
+// NewVP8 makes an encoder for the frame size, which should be a multiple of 16.
+func NewVP8(width, height int) *VP8 {
+	mbw, mbh := width/16, height/16
+	return &VP8{
+		width:   mbw * 16,
+		height:  mbh * 16,
+		mbw:     mbw,
+		mbh:     mbh,
+		y:       make([]uint8, mbw*16*mbh*16),
+		u:       make([]uint8, mbw*8*mbh*8),
+		v:       make([]uint8, mbw*8*mbh*8),
+		aboveY2: make([]uint8, mbw),
+		aboveUV: make([][4]uint8, mbw),
+	}
+}
+
+// Encode makes a key frame of the 4:2:0 picture of the encoder size.
+func (e *VP8) Encode(img *image.YCbCr) []byte {
+	var header, tokens boolEncoder
+	header.literal(0, 1) // color space
+	header.literal(0, 1) // clamping
+	header.literal(0, 1) // no segmentation
+	header.literal(0, 1) // normal filter
+	header.literal(0, 6) // no loop filter
+	header.literal(0, 3) // sharpness
+	header.literal(0, 1) // no filter deltas
+	header.literal(0, 2) // one token partition
+	header.literal(vp8Quant, 7)
+	header.literal(0, 5) // no quantizer deltas
+	header.literal(0, 1) // no entropy refresh
+	for i := range tokenUpdateProbs {
+		for j := range tokenUpdateProbs[i] {
+			for k := range tokenUpdateProbs[i][j] {
+				for _, p := range tokenUpdateProbs[i][j][k] {
+					header.put(false, p)
+				}
+			}
+		}
+	}
+	header.literal(0, 1) // no skipped macroblocks
+
+	clear(e.aboveY2)
+	clear(e.aboveUV)
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftY2, e.leftUV = 0, [4]uint8{}
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			// 16x16 DC prediction for luma and chroma
+			header.put(true, 145)
+			header.put(false, 156)
+			header.put(false, 163)
+			header.put(false, 142)
+			e.luma(&tokens, img, mbx, mby)
+			e.chroma(&tokens, img, mbx, mby)
+		}
+	}
+
+	first, second := header.flush(), tokens.flush()
+	size := len(first)
+	frame := make([]byte, 0, 10+size+len(second))
+	frame = append(frame,
+		// key frame, version 0, shown, the first partition size
+		byte(1<<4|size<<5), byte(size>>3), byte(size>>11),
+		0x9d, 0x01, 0x2a,
+		byte(e.width), byte(e.width>>8), byte(e.height), byte(e.height>>8),
+	)
+	frame = append(frame, first...)
+	return append(frame, second...)
+}
+
+// luma codes the macroblock as 16 flat 4x4 blocks with the Y2 (WHT) block.
+func (e *VP8) luma(b *boolEncoder, img *image.YCbCr, mbx, mby int) {
+	stride, x0, y0 := e.width, mbx*16, mby*16
+	pred := predictDC(e.y, stride, x0, y0, 16)
+
+	// the residual of each 4x4 block
+	var r [16]int
+	for i := range r {
+		x, y := x0+i%4*4, y0+i/4*4
+		r[i] = average(img.Y, img.YStride, x, y, 4) - pred
+	}
+	// the Y2 coefficients which the inverse WHT turns into 8 times the residual
+	var c [16]int
+	for k := range c {
+		u, v := k/4, k%4
+		s := 0
+		for i := range r {
+			s += r[i] * walsh[i/4][u] * walsh[i%4][v]
+		}
+		step := vp8Y2AC
+		if k == 0 {
+			step = vp8Y2DC
+		}
+		c[k] = div(4*s, step)
+	}
+	var zz [16]int
+	for i, z := range vp8Zigzag {
+		zz[i] = c[z]
+	}
+	nz := tokens(b, planeY2, int(e.leftY2+e.aboveY2[mbx]), zz[:], 0)
+	e.leftY2, e.aboveY2[mbx] = nz, nz
+	// the luma blocks have no AC coefficients
+	var none [16]int
+	for range 16 {
+		tokens(b, planeY1WithY2, 0, none[:], 1)
+	}
+
+	dc := inverseWHT(c)
+	for i := range dc {
+		fill(e.y, stride, x0+i%4*4, y0+i/4*4, 4, clamp(pred+(dc[i]+4)>>3))
+	}
+}
+
+// chroma codes the 4x4 chroma blocks of the macroblock with their DC only.
+func (e *VP8) chroma(b *boolEncoder, img *image.YCbCr, mbx, mby int) {
+	stride, x0, y0 := e.width/2, mbx*8, mby*8
+	for plane, p := range [][2][]uint8{{img.Cb, e.u}, {img.Cr, e.v}} {
+		src, recon := p[0], p[1]
+		pred := predictDC(recon, stride, x0, y0, 8)
+		var dc [4]int
+		for i := range dc {
+			x, y := x0+i%2*4, y0+i/2*4
+			c := div(8*(average(src, img.CStride, x, y, 4)-pred), vp8UVDC)
+			left, above := &e.leftUV[plane*2+i/2], &e.aboveUV[mbx][plane*2+i%2]
+			nz := tokens(b, planeUV, int(*left+*above), []int{c, 15: 0}, 0)
+			*left, *above = nz, nz
+			dc[i] = c * vp8UVDC
+		}
+		for i, c := range dc {
+			fill(recon, stride, x0+i%2*4, y0+i/2*4, 4, clamp(pred+(c+4)>>3))
+		}
+	}
+}
+
+// walsh is the Walsh-Hadamard basis of the inverse WHT.
+var walsh = [4][4]int{{1, 1, 1, 1}, {1, 1, -1, -1}, {1, -1, -1, 1}, {1, -1, 1, -1}}
+
+// inverseWHT is the decoder transform of the Y2 coefficients into the luma DCs.
+func inverseWHT(c [16]int) (out [16]int) {
+	c[0] *= vp8Y2DC
+	for i := 1; i < 16; i++ {
+		c[i] *= vp8Y2AC
+	}
+	var m [16]int
+	for i := 0; i < 4; i++ {
+		a0, a1 := c[i]+c[12+i], c[4+i]+c[8+i]
+		a2, a3 := c[4+i]-c[8+i], c[i]-c[12+i]
+		m[i], m[8+i], m[4+i], m[12+i] = a0+a1, a0-a1, a3+a2, a3-a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[i*4] + 3
+		a0, a1 := dc+m[i*4+3], m[i*4+1]+m[i*4+2]
+		a2, a3 := m[i*4+1]-m[i*4+2], dc-m[i*4+3]
+		out[i*4], out[i*4+1], out[i*4+2], out[i*4+3] = (a0+a1)>>3, (a3+a2)>>3, (a0-a1)>>3, (a3-a2)>>3
+	}
+	return out
+}
+
+// predictDC is the DC prediction of a block from the reconstructed pixels above and to the left.
+func predictDC(plane []uint8, stride, x0, y0, size int) int {
+	sum, n := 0, 0
+	if y0 > 0 {
+		for i := 0; i < size; i++ {
+			sum += int(plane[(y0-1)*stride+x0+i])
+		}
+		n += size
+	}
+	if x0 > 0 {
+		for j := 0; j < size; j++ {
+			sum += int(plane[(y0+j)*stride+x0-1])
+		}
+		n += size
+	}
+	if n == 0 {
+		return 128
+	}
+	return (sum + n/2) / n
+}
+
+func average(plane []uint8, stride, x0, y0, size int) int {
+	sum := 0
+	for y := y0; y < y0+size; y++ {
+		for x := x0; x < x0+size; x++ {
+			sum += int(plane[y*stride+x])
+		}
+	}
+	return (sum + size*size/2) / (size * size)
+}
+
+func fill(plane []uint8, stride, x0, y0, size int, v uint8) {
+	for y := y0; y < y0+size; y++ {
+		for x := x0; x < x0+size; x++ {
+			plane[y*stride+x] = v
+		}
+	}
+}
+
+func clamp(v int) uint8 { return uint8(min(max(v, 0), 255)) }
+
+// div divides with rounding half away from zero.
+func div(a, b int) int {
+	if a < 0 {
+		return -((-a + b/2) / b)
+	}
+	return (a + b/2) / b
+}
+
+// tokens codes the block coefficients (in the scan order) from the first one
+// and returns 1 if there are any non-zero ones for the contexts of the next blocks.
+func tokens(b *boolEncoder, plane, ctx int, c []int, first int) uint8 {
+	last := -1
+	for i := first; i < 16; i++ {
+		if c[i] != 0 {
+			last = i
+		}
+	}
+	p := &defaultTokenProbs[plane][vp8Bands[first]][ctx]
+	b.put(last >= 0, p[0])
+	if last < 0 {
+		return 0
+	}
+	for i := first; i <= last; i++ {
+		v := c[i]
+		if v == 0 {
+			b.put(false, p[1])
+			p = &defaultTokenProbs[plane][vp8Bands[i+1]][0]
+			continue
+		}
+		b.put(true, p[1])
+		abs := max(v, -v)
+		value(b, abs, p)
+		b.put(v < 0, 128)
+		ctx = 2
+		if abs == 1 {
+			ctx = 1
+		}
+		p = &defaultTokenProbs[plane][vp8Bands[i+1]][ctx]
+		if i < 15 {
+			b.put(i < last, p[0])
+		}
+	}
+	return 1
+}
+
+// value codes the absolute value of a non-zero coefficient.
+func value(b *boolEncoder, v int, p *[11]uint8) {
+	b.put(v > 1, p[2])
+	switch {
+	case v == 1:
+	case v <= 4:
+		b.put(false, p[3])
+		b.put(v > 2, p[4])
+		if v > 2 {
+			b.put(v == 4, p[5])
+		}
+	case v <= 10:
+		b.put(true, p[3])
+		b.put(false, p[6])
+		b.put(v > 6, p[7])
+		if v <= 6 {
+			b.put(v == 6, 159)
+		} else {
+			b.put((v-7)&2 != 0, 165)
+			b.put((v-7)&1 != 0, 145)
+		}
+	default:
+		b.put(true, p[3])
+		b.put(true, p[6])
+		cat := 0
+		for cat < 3 && v >= 3+(16<<cat) {
+			cat++
+		}
+		b.put(cat >= 2, p[8])
+		b.put(cat%2 == 1, p[9+cat/2])
+		extra, probs := v-3-(8<<cat), vp8Cat[cat]
+		for i, prob := range probs {
+			b.put(extra>>(len(probs)-1-i)&1 != 0, prob)
+		}
+	}
+}
+
+// boolEncoder is the boolean entropy encoder of RFC 6386 section 7.3.
+type boolEncoder struct {
+	out      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func (e *boolEncoder) put(bit bool, prob uint8) {
+	if e.rng == 0 {
+		e.rng, e.bitCount = 255, 24
+	}
+	split := 1 + (e.rng-1)*uint32(prob)>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		if e.bitCount--; e.bitCount == 0 {
+			e.out = append(e.out, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+// literal puts n bits of v with the even probability.
+func (e *boolEncoder) literal(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.put(v>>i&1 != 0, 128)
+	}
+}
+
+func (e *boolEncoder) carry() {
+	for i := len(e.out) - 1; i >= 0; i-- {
+		if e.out[i]++; e.out[i] != 0 {
+			return
+		}
+	}
+}
+
+func (e *boolEncoder) flush() []byte {
+	if e.rng == 0 {
+		e.rng, e.bitCount = 255, 24
+	}
+	c, v := e.bitCount, e.bottom
+	if v&(1<<(32-c)) != 0 {
+		e.carry()
+	}
+	v <<= c & 7
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for range 4 {
+		e.out = append(e.out, byte(v>>24))
+		v <<= 8
+	}
+	return e.out
+}
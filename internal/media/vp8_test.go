@@ -0,0 +1,50 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"golang.org/x/image/vp8"
+)
+
+// TestVP8RoundTrip decodes the encoded test patterns with another decoder,
+// where the pattern edges are on the block grid, so the pictures are almost exact.
+func TestVP8RoundTrip(t *testing.T) {
+	for _, size := range []image.Point{{640, 480}, {176, 144}} {
+		enc := NewVP8(size.X, size.Y)
+		img := image.NewYCbCr(image.Rect(0, 0, size.X, size.Y), image.YCbCrSubsampleRatio420)
+		for _, frame := range []int{0, 1, 57, 123456} {
+			Pattern(img, frame)
+			data := enc.Encode(img)
+
+			d := vp8.NewDecoder()
+			d.Init(bytes.NewReader(data), len(data))
+			h, err := d.DecodeFrameHeader()
+			if err != nil {
+				t.Fatalf("%v frame %v: %v", size, frame, err)
+			}
+			if !h.KeyFrame || h.Width != size.X || h.Height != size.Y {
+				t.Errorf("%v frame %v: got %vx%v key frame %v", size, frame, h.Width, h.Height, h.KeyFrame)
+			}
+			out, err := d.DecodeFrame()
+			if err != nil {
+				t.Fatalf("%v frame %v: %v", size, frame, err)
+			}
+
+			diff := 0
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					diff = max(diff, absDiff(img.Y[img.YOffset(x, y)], out.Y[out.YOffset(x, y)]))
+					i, j := img.COffset(x, y), out.COffset(x, y)
+					diff = max(diff, absDiff(img.Cb[i], out.Cb[j]), absDiff(img.Cr[i], out.Cr[j]))
+				}
+			}
+			if diff > 1 {
+				t.Errorf("%v frame %v: got max pixel diff %v, want up to 1", size, frame, diff)
+			}
+		}
+	}
+}
+
+func absDiff(a, b uint8) int { return max(int(a), int(b)) - min(int(a), int(b)) }
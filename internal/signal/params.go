@@ -0,0 +1,86 @@
+package signal
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
+)
+
+// params reads typed session options from the websocket URL query
+// and keeps the first parsing error.
+type params struct {
+	url.Values
+	err error
+}
+
+func (p *params) bool(name string) bool { return p.Get(name) == "true" }
+
+func (p *params) int(name string) int {
+	v := p.Get(name)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("bad %v [%v], should be a number", name, v)
+	}
+	return i
+}
+
+// list splits a comma-separated value skipping empty items.
+func (p *params) list(name string) []string { return list(p.Get(name)) }
+
+func list(v string) []string {
+	var items []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// PeerConfig makes a validated peer configuration from
+// the session options, which are the websocket URL query params.
+func PeerConfig(q url.Values) (webrtc.Config, error) {
+	p := params{Values: q}
+	return p.peerConfig()
+}
+
+// peerConfig makes a validated peer configuration from the session options.
+func (p *params) peerConfig() (webrtc.Config, error) {
+	iceServers, err := webrtc.ParseICEServers(p.Get("ice_servers"))
+	if err != nil {
+		return webrtc.Config{}, err
+	}
+	conf := webrtc.Config{
+		DisableDefaultInterceptors: p.bool("disable_interceptors"),
+		DisableLoopback:            p.bool("disable_loopback"),
+		DisableMDNS:                p.bool("disable_mdns"),
+		DtlsRole:                   p.Get("dtls_role"),
+		ExcludeIPs:                 p.list("exclude_ips"),
+		ExcludeInterfaces:          p.list("exclude_interfaces"),
+		IceLite:                    p.bool("ice_lite"),
+		IcePortMin:                 p.int("ice_port_min"),
+		IcePortMax:                 p.int("ice_port_max"),
+		IceServers:                 iceServers,
+		Interfaces:                 p.list("interfaces"),
+		IPs:                        p.list("ips"),
+		Nat1to1:                    p.Get("nat1to1"),
+		NetworkTypes:               p.list("network_types"),
+		RelayOnly:                  p.bool("ice_relay_only"),
+		SCTPMaxMessageSize:         p.int("sctp_max_message_size"),
+		SCTPReceiveBuffer:          p.int("sctp_receive_buffer"),
+		SCTPRTOMax:                 p.int("sctp_rto_max"),
+		SinglePort:                 p.int("port"),
+		TCPOnly:                    p.bool("ice_tcp_only"),
+		TCPPort:                    p.int("tcp_port"),
+	}
+	if p.err != nil {
+		return conf, p.err
+	}
+	return conf, conf.Validate()
+}
@@ -0,0 +1,172 @@
+package signal
+
+import (
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/api"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
+	"golang.org/x/net/websocket"
+)
+
+// roomSize is the number of browsers in a room.
+const roomSize = 2
+
+// room relays signaling between two browsers with the same room code.
+type room struct {
+	code    string
+	mu      sync.Mutex
+	members []*member
+}
+
+// member is a browser in a room.
+type member struct {
+	name   string
+	signal *socket
+	log    webrtc.LogFn
+}
+
+// rooms is a set of rooms with at least one browser.
+type rooms struct {
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// join adds the browser into the room and relays
+// its messages until the websocket is closed.
+func (rs *rooms) join(code string, conn *websocket.Conn, conf Config) {
+	m := member{signal: newSocket()}
+	m.log = remoteLogger(m.signal)
+
+	r, ok := rs.enter(code, &m)
+	if !ok {
+		m.signal.close()
+		log.Printf("room %v is full", code)
+		_ = websocket.JSON.Send(conn, api.NewLog(api.Log{Tag: "room", Text: "room " + code + " is full"}))
+		_ = websocket.JSON.Send(conn, api.NewClose())
+		return
+	}
+	defer rs.leave(r, &m)
+	// the lines logged while joining are replayed
+	m.signal.attach(conn, 0)
+
+	if conf.Turn != nil {
+		ice, err := conf.Turn.ICEServer(strconv.FormatUint(mrand.Uint64(), 36))
+		if err != nil {
+			m.log("sys", "turn fail: %v", err)
+		} else {
+			m.log("sys", "using TURN server %v", conf.Turn)
+			_ = m.signal.send(api.NewIceServers([]webrtc.ICEServer{ice}))
+		}
+	}
+	r.notify()
+
+	for {
+		var msg api.Message
+		if err := receive(conn, &msg); ended(conn, err) {
+			log.Printf("Signal has been closed!")
+			return
+		} else if err != nil {
+			m.log("sys", "err: %v", err)
+			return
+		}
+		switch msg.T {
+		case api.WebrtcOffer, api.WebrtcAnswer, api.WebrtcIce:
+			r.relay(&m, msg)
+		case api.WebrtcClose:
+			m.log("sig", "!close")
+			_ = m.signal.send(api.NewClose())
+			return
+		default:
+			m.log("room", "err: [%v] is not supported in rooms", msg.T)
+		}
+	}
+}
+
+// enter adds the member into the room with the code,
+// a new one if there is none, and returns false if it is full.
+func (rs *rooms) enter(code string, m *member) (*room, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r, ok := rs.rooms[code]
+	if !ok {
+		r = &room{code: code}
+		rs.rooms[code] = r
+	}
+	return r, r.add(m)
+}
+
+func (rs *rooms) leave(r *room, m *member) {
+	rs.mu.Lock()
+	if r.remove(m) == 0 {
+		delete(rs.rooms, r.code)
+	}
+	rs.mu.Unlock()
+	m.signal.close()
+	r.notify()
+}
+
+func (r *room) add(m *member) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.members) == roomSize {
+		return false
+	}
+	m.name = "A"
+	if len(r.members) > 0 && r.members[0].name == "A" {
+		m.name = "B"
+	}
+	r.members = append(r.members, m)
+	m.log("room", "joined room %v as peer %v", r.code, m.name)
+	for _, o := range r.members {
+		if o != m {
+			o.log("room", "peer %v has joined", m.name)
+		}
+	}
+	return true
+}
+
+// remove returns the number of the members left.
+func (r *room) remove(m *member) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, o := range r.members {
+		if o == m {
+			r.members = append(r.members[:i], r.members[i+1:]...)
+			break
+		}
+	}
+	for _, o := range r.members {
+		o.log("room", "peer %v has left", m.name)
+	}
+	return len(r.members)
+}
+
+// notify sends the room state to the members,
+// the one who came first makes the offer when the room is full.
+func (r *room) notify() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, m := range r.members {
+		state := api.RoomState{Code: r.code, Peers: len(r.members), Offer: i == 0 && len(r.members) == roomSize}
+		_ = m.signal.send(api.NewRoom(state))
+	}
+}
+
+// relay forwards the message to the other member.
+func (r *room) relay(from *member, m api.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, to := range r.members {
+		if to == from {
+			continue
+		}
+		from.log("room", "%v → peer %v", m.T, to.name)
+		to.log("room", "%v ← peer %v", m.T, from.name)
+		_ = to.signal.send(m)
+		return
+	}
+	from.log("room", "err: no peer for %v, it is dropped", m.T)
+}
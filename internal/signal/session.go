@@ -0,0 +1,462 @@
+package signal
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/api"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/media"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/stun"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/turn"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
+	"golang.org/x/net/websocket"
+)
+
+// session is a server peer connection that outlives its signaling websocket
+// for a grace period, so the client can reattach and get the missed messages.
+type session struct {
+	id     string
+	signal *socket
+	log    webrtc.LogFn
+	peer   *webrtc.Peer
+	ping   atomic.Pointer[webrtc.Ping]
+	// seqs are the reliability test channels
+	seqs []*webrtc.Sequence
+	// testing is set while a throughput or message size test runs
+	testing atomic.Bool
+	done    chan struct{}
+
+	// mu serializes the client messages and guards the fields below
+	mu     sync.Mutex
+	closed bool
+	expiry *time.Timer
+	onEnd  func()
+}
+
+// registry is a set of live sessions.
+type registry struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func (r *registry) add(s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.id] = s
+	s.onEnd = func() { r.remove(s.id) }
+}
+
+func (r *registry) get(id string) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[id]
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+func newSession() *session {
+	s := session{id: rand.Text(), signal: newSocket(), done: make(chan struct{})}
+	s.log = remoteLogger(s.signal)
+	return &s
+}
+
+// start sets up the peer connection with the session options.
+func (s *session) start(conf Config, q params) error {
+	flip := q.bool("flip_offer_side")
+	testNat := q.bool("test_nat")
+	echo := q.bool("echo")
+	synthetic := q.bool("synthetic")
+	play := q.list("play")
+	if len(play) > 0 && conf.MediaDir == "" {
+		return errors.New("no media directory on the server")
+	}
+	record := q.bool("record")
+	if record && conf.RecordDir == "" {
+		return errors.New("no record directory on the server")
+	}
+	turnCheck := q.bool("turn_check")
+	stunServers := conf.StunServers
+	if v := q.list("stun_servers"); len(v) > 0 {
+		stunServers = v
+	}
+	statsInterval := q.int("stats_interval")
+	pingInterval := defaultPingInterval
+	if v := q.int("ping_interval"); v > 0 {
+		pingInterval = max(time.Duration(v)*time.Millisecond, minPingInterval)
+	}
+	channels, err := webrtc.ParseChannels(q.Get("channels"))
+	if err != nil {
+		return err
+	}
+
+	_log := s.log
+	logger := webrtc.NewLoggerFactory(q.Get("log_level"), _log)
+	_log("sys", "log level is %v", logger.Level)
+	_log("sys", "secure? %v", q.bool("ssl"))
+
+	peerConf, err := q.peerConfig()
+	if err != nil {
+		return err
+	}
+	peerConf.Logger = logger
+	peerConf.Mux = conf.Mux
+
+	if testNat {
+		_ = s.signal.send(api.NewNat(stun.Main(stunServers, logger.NewLogger("stun"))))
+	}
+
+	if conf.Turn != nil {
+		ice, err := conf.Turn.ICEServer(strconv.FormatUint(mrand.Uint64(), 36))
+		if err != nil {
+			return fmt.Errorf("turn fail: %w", err)
+		}
+		peerConf.IceServers = append(peerConf.IceServers, ice)
+		_log("sys", "using TURN server %v", conf.Turn)
+		_ = s.signal.send(api.NewIceServers([]webrtc.ICEServer{ice}))
+	}
+
+	if turnCheck {
+		checks := turn.CheckServers(peerConf.IceServers, turnCheckTimeout)
+		for _, c := range checks {
+			if c.Err != "" {
+				_log("turn", "%v fail (%v): %v", c.URL, c.Reason, c.Err)
+			} else {
+				_log("turn", "%v ok, relayed: %v, mapped: %v, lifetime: %vs", c.URL, c.Relayed, c.Mapped, c.Lifetime)
+			}
+		}
+		_ = s.signal.send(api.NewTurnCheck(checks))
+	}
+
+	p2p, err := webrtc.NewPeerConnection(peerConf)
+	if err != nil {
+		return err
+	}
+	s.peer = p2p
+
+	_ = s.signal.send(api.NewConfig(p2p.Config()))
+
+	if flip {
+		dc, err := p2p.CreateDataChannel("data", nil)
+		if err != nil {
+			return fmt.Errorf("datachannel fail: %w", err)
+		}
+		s.ping.Store(webrtc.NewPing(dc, pingInterval, s.done))
+	}
+
+	for _, c := range channels {
+		dc, err := p2p.CreateDataChannel(c.Label, &c.Init)
+		if err != nil {
+			return fmt.Errorf("datachannel %v fail: %w", c.Label, err)
+		}
+		s.seqs = append(s.seqs, webrtc.NewSequence(dc, s.done, func(st webrtc.SequenceStats) { _log("dc", "sequence %v", st) }))
+	}
+	if len(channels) > 0 {
+		_log("dc", "%d reliability test channels", len(channels))
+	}
+
+	p2p.OnIceCandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		_ = s.signal.send(api.NewIce(*c))
+	})
+
+	p2p.OnIceConnectionStateChange(logState[webrtc.ICEConnectionState]("ice", _log))
+	p2p.OnConnectionStateChange(logState[webrtc.PeerConnectionState]("rtc", _log))
+	p2p.OnIceGatheringStateChange(logState[webrtc.ICEGatheringState]("ice", _log))
+	p2p.OnSignalingStateChange(logState[webrtc.SignalingState]("sig", _log))
+	p2p.OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		_log("ice", "selected pair %v <-> %v, tcp: %v", pair.Local, pair.Remote, pair.Local.Protocol == webrtc.ICEProtocolTCP)
+	})
+
+	p2p.OnDataChannel(func(d *webrtc.DataChannel) { s.ping.Store(webrtc.NewPing(d, pingInterval, s.done)) })
+
+	if conf.MediaDir != "" {
+		if files, err := media.Files(conf.MediaDir); err != nil {
+			_log("sys", "media files err: %v", err)
+		} else {
+			_log("sys", "media files to play: %v", strings.Join(files, ", "))
+		}
+	}
+
+	if echo || synthetic || len(play) > 0 {
+		p2p.OnRenegotiation(func() {
+			offer, err := p2p.CreateOffer()
+			if err != nil {
+				_log("rtc", "renegotiation err: %v", err)
+				return
+			}
+			_log("rtc", "renegotiation offer")
+			_ = s.signal.send(api.NewSDP(*offer, api.WebrtcOffer))
+		})
+	}
+	rtcLog := func(format string, v ...any) { _log("rtc", format, v...) }
+	if echo {
+		_log("rtc", "echo mode, the media tracks are sent back")
+		p2p.Echo(rtcLog)
+	}
+	if record {
+		_log("rtc", "record mode, the media tracks are saved")
+		// the random part keeps the files of the other sessions from the download
+		prefix := time.Now().Format("20060102-150405") + "-" + strings.ToLower(rand.Text()[:16])
+		p2p.Record(conf.RecordDir, prefix, func(r webrtc.Recording) {
+			_ = s.signal.send(api.NewRecording(r, RecordingsPath+r.Name))
+		}, rtcLog)
+	}
+	if synthetic {
+		if err := p2p.Synthetic(s.done, rtcLog); err != nil {
+			return fmt.Errorf("synthetic media fail: %w", err)
+		}
+	}
+	if len(play) > 0 {
+		if err := p2p.Play(conf.MediaDir, play, s.done, rtcLog); err != nil {
+			return fmt.Errorf("play fail: %w", err)
+		}
+	}
+
+	if statsInterval > 0 {
+		interval := max(time.Duration(statsInterval)*time.Millisecond, minStatsInterval)
+		_log("sys", "stats every %v", interval)
+		p2p.SampleStats(interval, s.done, func(st webrtc.Stats) { _ = s.signal.send(api.NewStats(st)) })
+	}
+	return nil
+}
+
+// serve handles the client messages from the websocket until it is closed.
+func (s *session) serve(conn *websocket.Conn) {
+	for {
+		var m api.Message
+		if err := receive(conn, &m); ended(conn, err) {
+			log.Printf("Signal has been closed!")
+			return
+		} else if err != nil {
+			s.log("sys", "err: %v", err)
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				continue
+			}
+			// the websocket is broken
+			return
+		}
+		if !s.message(m) {
+			s.close()
+			return
+		}
+	}
+}
+
+// message handles a client message unless the session is closed.
+func (s *session) message(m api.Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed && s.handle(m)
+}
+
+// handle reacts to a client message and returns false if the session is over.
+func (s *session) handle(m api.Message) bool {
+	_log, p2p := s.log, s.peer
+	switch m.T {
+	case api.WebrtcAnswer,
+		api.WebrtcOffer:
+		if sdp, err := api.NewSessionDescription(m.Payload); err == nil {
+			if err = p2p.SetRemoteSDP(sdp.SessionDescription); err != nil {
+				_log("rtc", "err: %v", err)
+				return false
+			}
+		}
+		if m.T == api.WebrtcAnswer {
+			return true
+		}
+		answer, err := p2p.CreateAnswer()
+		if err != nil {
+			_log("rtc", "err: %v", err)
+			return false
+		}
+		_ = s.signal.send(api.NewSDP(*answer, api.WebrtcAnswer))
+	case api.WebrtcIce:
+		if candidate, err := api.NewIceCandidateInit(m.Payload); err == nil {
+			if err = p2p.AddIceCandidate(candidate); err != nil {
+				_log("ice", "err: %v", err)
+				return false
+			}
+		}
+	case api.WebrtcWaitingOffer:
+		offer, err := p2p.CreateOffer()
+		if err != nil {
+			_log("rtc", "err: %v", err)
+			return false
+		}
+		_ = s.signal.send(api.NewSDP(*offer, api.WebrtcOffer))
+	case api.MessageThroughput:
+		req, err := api.NewThroughputRequest(m.Payload)
+		if err != nil {
+			_log("dc", "err: %v", err)
+			return true
+		}
+		s.throughput(req)
+	case api.MessageSize:
+		req, err := api.NewMessageSizeRequest(m.Payload)
+		if err != nil {
+			_log("dc", "err: %v", err)
+			return true
+		}
+		s.messageSize(req)
+	case api.WebrtcClose:
+		_log("sig", "!close")
+		return false
+	default:
+		_log("sys", "err: unknown message [%v]", m.T)
+		return false
+	}
+	return true
+}
+
+// throughput runs the data channel throughput test in the background.
+func (s *session) throughput(req api.ThroughputRequest) {
+	opts := webrtc.ThroughputOptions{Duration: defaultThroughputDuration}
+	if req.Duration > 0 {
+		opts.Duration = min(time.Duration(req.Duration)*time.Millisecond, maxThroughputDuration)
+	}
+	switch req.Direction {
+	case "up":
+		opts.Receive = true
+	case "down":
+		opts.Send = true
+	case "both", "":
+		opts.Send, opts.Receive = true, true
+	default:
+		s.log("dc", "err: unknown throughput direction [%v]", req.Direction)
+		return
+	}
+	if s.peer == nil || !s.testing.CompareAndSwap(false, true) {
+		s.log("dc", "err: a test is running or there is no connection")
+		return
+	}
+	s.log("dc", "throughput test for %v, send: %v, receive: %v", opts.Duration, opts.Send, opts.Receive)
+	go func() {
+		defer s.testing.Store(false)
+		err := s.peer.Throughput(opts, func(r webrtc.ThroughputResult) { s.log("dc", "throughput: %v", r) })
+		if err != nil {
+			s.log("dc", "throughput err: %v", err)
+		}
+	}()
+}
+
+// messageSize runs the max message size test in the background,
+// each direction in its own channel as too large messages may close it.
+func (s *session) messageSize(req api.MessageSizeRequest) {
+	var directions []bool
+	switch req.Direction {
+	case "up":
+		directions = []bool{false}
+	case "down":
+		directions = []bool{true}
+	case "both", "":
+		directions = []bool{true, false}
+	default:
+		s.log("dc", "err: unknown message size direction [%v]", req.Direction)
+		return
+	}
+	if s.peer == nil || !s.testing.CompareAndSwap(false, true) {
+		s.log("dc", "err: a test is running or there is no connection")
+		return
+	}
+	s.log("dc", "max message size test, %v", req.Direction)
+	var wg sync.WaitGroup
+	for _, send := range directions {
+		wg.Go(func() {
+			err := s.peer.MessageSize(send, func(r webrtc.MessageSizeResult) { s.log("dc", "message size: %v", r) })
+			if err != nil {
+				s.log("dc", "message size err: %v", err)
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		s.testing.Store(false)
+	}()
+}
+
+// resume reattaches the client websocket and replays
+// the messages after the last one the client has seen.
+func (s *session) resume(conn *websocket.Conn, last uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	replayed, lost := s.signal.attach(conn, last)
+	s.log("sys", "session %v has been resumed, %d messages replayed", s.id, replayed)
+	if lost > 0 {
+		s.log("sys", "%d messages are lost, they are too old", lost)
+	}
+	return true
+}
+
+// expire closes the session after the grace period
+// unless the client reattaches.
+func (s *session) expire(grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	log.Printf("session %v is waiting for the client for %v", s.id, grace)
+	s.expiry = time.AfterFunc(grace, func() {
+		log.Printf("session %v has expired", s.id)
+		s.close()
+	})
+}
+
+func (s *session) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	s.mu.Unlock()
+
+	close(s.done)
+	for _, seq := range s.seqs {
+		seq.Report()
+	}
+	if p := s.ping.Load(); p != nil {
+		summary := p.Stats().String()
+		if st := s.peer.Stats(); st.Selected != nil {
+			summary += fmt.Sprintf(", ICE rtt %v", time.Duration(st.Selected.RTT*float64(time.Second)).Round(10*time.Microsecond))
+		}
+		s.log("dc", "%v", summary)
+	}
+	if s.peer != nil {
+		if err := s.peer.Close(); err != nil {
+			log.Printf("close err: %v", err)
+		}
+	}
+	_ = s.signal.send(api.NewClose())
+	s.signal.close()
+	if s.onEnd != nil {
+		s.onEnd()
+	}
+}
@@ -1,53 +1,68 @@
 package signal
 
 import (
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/api"
-	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/stun"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/turn"
 	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/webrtc"
 	"golang.org/x/net/websocket"
 )
 
-type socket struct {
-	*websocket.Conn
-	closed bool
+// Config holds server-wide session defaults.
+type Config struct {
+	// StunServers are used in the NAT behavior tests
+	// unless a session provides its own list.
+	StunServers []string
+	// Turn is an optional embedded TURN server
+	// added into the ICE servers of every session.
+	Turn *turn.Server
+	// Mux is an optional single-port mux shared by all sessions.
+	Mux *webrtc.Mux
+	// Grace is the time a session waits for the client
+	// to reconnect after its websocket is closed.
+	Grace time.Duration
+	// MediaDir is an optional directory of the IVF and Ogg/Opus files
+	// the sessions may play.
+	MediaDir string
+	// RecordDir is an optional directory where the sessions
+	// may save the incoming tracks.
+	RecordDir string
 }
 
-func (s *socket) close() { s.closed = true }
-func (s *socket) ended(err error) bool {
-	if errors.Is(err, io.EOF) {
-		if err := s.Conn.Close(); err != nil {
-			log.Printf("error: failed signal close, %v", err)
-		}
-		return true
-	}
-	return false
-}
-func (s *socket) receive(m interface{}) error { return websocket.JSON.Receive(s.Conn, m) }
-func (s *socket) send(m interface{}) error {
-	if s.closed {
-		return nil
-	}
-	return websocket.JSON.Send(s.Conn, m)
-}
+// RecordingsPath is the URL path of the recorded files relative to the web root.
+const RecordingsPath = "recordings/"
+
+const (
+	// minStatsInterval limits how often the client may ask for stats.
+	minStatsInterval = 250 * time.Millisecond
+	// turnCheckTimeout is the time limit for a single TURN server check.
+	turnCheckTimeout = 5 * time.Second
+	// defaultPingInterval is the data channel ping interval
+	// unless the client asks for another one.
+	defaultPingInterval = time.Second
+	// minPingInterval limits how often the client may ask for pings.
+	minPingInterval = 50 * time.Millisecond
+	// defaultThroughputDuration is the throughput test duration
+	// unless the client asks for another one.
+	defaultThroughputDuration = 5 * time.Second
+	// maxThroughputDuration limits the throughput test duration.
+	maxThroughputDuration = time.Minute
+	// defaultGrace is the default session reconnect time.
+	defaultGrace = 30 * time.Second
+)
 
 func remoteLogger(s *socket) webrtc.LogFn {
 	return func(tag string, format string, v ...any) string {
 		m := fmt.Sprintf(format, v...)
 		line := fmt.Sprintf("%s %s", tag, m)
-		log.Printf(line)
-		if !s.closed {
-			if err := s.send(api.NewLog(api.Log{Tag: tag, Text: m})); err != nil {
-				log.Printf("log [%v] err: %v", line, err)
-			}
-		}
+		log.Print(line)
+		s.log(api.Log{Tag: tag, Text: m})
 		return line
 	}
 }
@@ -56,148 +71,61 @@ func logState[T webrtc.State](tag string, l webrtc.LogFn) func(state T) {
 	return func(state T) { l(tag, "→ %s", state) }
 }
 
-func Handler() websocket.Handler {
-	status := func() string { return fmt.Sprintf("%08b", rand.Intn(256)) }
-
-	sendGarbage := func(d *webrtc.DataChannel, done chan struct{}) func() {
-		return func() {
-			_ = d.SendText(status())
-			ticker := time.NewTicker(10 * time.Second)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-done:
-					return
-				case <-ticker.C:
-					_ = d.SendText(status())
-				}
-			}
-		}
+func Handler(conf Config) websocket.Handler {
+	sessions := registry{sessions: map[string]*session{}}
+	rooms := rooms{rooms: map[string]*room{}}
+	grace := conf.Grace
+	if grace == 0 {
+		grace = defaultGrace
 	}
 
 	return func(wc *websocket.Conn) {
-		signal := socket{wc, false}
-		done := make(chan struct{})
-
-		q := signal.Request().URL.Query()
-
-		disableInterceptors := q.Get("disable_interceptors") == "true"
-		disableMDNS := q.Get("disable_mdns") == "true"
-		flip := q.Get("flip_offer_side") == "true"
-		iceServers := strings.Split(q.Get("ice_servers"), ",")
-		logLevel := q.Get("log_level")
-		port := q.Get("port")
-		testNat := q.Get("test_nat") == "true"
-		nat1to1 := q.Get("nat1to1")
-		ssl := q.Get("ssl") == "true"
-
-		_log := remoteLogger(&signal)
-		logger := webrtc.NewLoggerFactory(logLevel, _log)
-		_log("sys", "log level is %v", logger.Level)
-		_log("sys", "secure? %v", ssl)
-
-		if testNat {
-			stun.Main(logger.NewLogger("stun"))
-		}
+		q := params{Values: wc.Request().URL.Query()}
 
-		defer func() {
-			// !to wait for message drain
-			done <- struct{}{}
-			signal.close()
-		}()
-
-		p2p, err := webrtc.NewPeerConnection(iceServers, disableInterceptors, port, nat1to1, disableMDNS, logger)
-		if err != nil {
-			_log("sys", "fail: %v", err)
+		if code := q.Get("room"); code != "" {
+			rooms.join(code, wc, conf)
 			return
 		}
 
-		if flip {
-			dc, err := p2p.CreateDataChannel("data")
-			if err != nil {
-				_log("sys", "datachannel fail: %v", err)
-				return
-			}
-			dc.OnOpen(sendGarbage(dc, done))
-		}
-
-		p2p.OnIceCandidate(func(c *webrtc.ICECandidate) {
-			if c == nil {
+		var s *session
+		if id := q.Get("session"); id != "" {
+			last, _ := strconv.ParseUint(q.Get("seq"), 10, 64)
+			if s = sessions.get(id); s == nil || !s.resume(wc, last) {
+				log.Printf("session %v is not found", id)
+				_ = websocket.JSON.Send(wc, api.NewLog(api.Log{Tag: "sys", Text: "session " + id + " has expired"}))
+				_ = websocket.JSON.Send(wc, api.NewClose())
 				return
 			}
-			if err := signal.send(api.NewIce(*c)); err != nil {
-				_log("sys", "fail: %v", err)
-			}
-		})
-
-		p2p.OnIceConnectionStateChange(logState[webrtc.ICEConnectionState]("ice", _log))
-		p2p.OnConnectionStateChange(logState[webrtc.PeerConnectionState]("rtc", _log))
-		p2p.OnIceGatheringStateChange(logState[webrtc.ICEGatheringState]("ice", _log))
-		p2p.OnSignalingStateChange(logState[webrtc.SignalingState]("sig", _log))
-
-		p2p.OnDataChannel(func(d *webrtc.DataChannel) { d.OnOpen(sendGarbage(d, done)) })
-
-		for {
-			var m api.Message
-			if err := signal.receive(&m); signal.ended(err) {
-				log.Printf("Signal has been closed!")
+		} else {
+			s = newSession()
+			s.signal.attach(wc, 0)
+			if err := s.start(conf, q); err != nil {
+				s.log("sys", "fail: %v", err)
+				s.close()
 				return
-			} else if err != nil {
-				_log("sys", "err: %v", err)
-				continue
 			}
+			// the session may be resumed only when it has the peer
+			sessions.add(s)
+			_ = s.signal.send(api.NewSession(s.id))
+		}
 
-			switch m.T {
-			case api.WebrtcAnswer,
-				api.WebrtcOffer:
-				if sdp, err := api.NewSessionDescription(m.Payload); err == nil {
-					if err = p2p.SetRemoteSDP(sdp.SessionDescription); err != nil {
-						_log("rtc", "err: %v", err)
-						return
-					}
-				}
-				if m.T == api.WebrtcAnswer {
-					continue
-				}
-				answer, err := p2p.CreateAnswer()
-				if err != nil {
-					_log("rtc", "err: %v", err)
-					return
-				}
-				if err = signal.send(api.NewSDP(*answer, api.WebrtcAnswer)); err != nil {
-					_log("rtc", "err: %v", err)
-					return
-				}
-			case api.WebrtcIce:
-				if candidate, err := api.NewIceCandidateInit(m.Payload); err == nil {
-					if err = p2p.AddIceCandidate(candidate); err != nil {
-						_log("ice", "err: %v", err)
-						return
-					}
-				}
-			case api.WebrtcWaitingOffer:
-				offer, err := p2p.CreateOffer()
-				if err != nil {
-					_log("rtc", "err: %v", err)
-					return
-				}
-				if err = signal.send(api.NewSDP(*offer, api.WebrtcOffer)); err != nil {
-					_log("rtc", "err: %v", err)
-					return
-				}
-			case api.WebrtcClose:
-				_log("sig", "!close")
-				err := p2p.Close()
-				if err != nil {
-					log.Printf("close err: %v", err)
-				}
-				if err := signal.send(api.NewClose()); err != nil {
-					_log("sig", "err: %v", err)
-				}
-			default:
-				_log("sys", "err: unknown message [%v]", m.T)
-				return
-			}
+		s.serve(wc)
+		if s.signal.detach(wc) {
+			s.expire(grace)
 		}
 	}
 }
+
+// Recordings serves the recorded files of the directory for the download
+// without listing them, so only the session that has them knows the names.
+func Recordings(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.StripPrefix("/"+RecordingsPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment")
+		files.ServeHTTP(w, r)
+	}))
+}
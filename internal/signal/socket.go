@@ -0,0 +1,259 @@
+package signal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/api"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// queueSize is the number of signaling messages waiting to be written,
+	// they are never dropped, so senders wait when it is full.
+	queueSize = 256
+	// logQueueSize is the number of log lines waiting to be written,
+	// newer lines are dropped (and counted) when it is full.
+	logQueueSize = 1024
+	// maxLogBatch is the max number of log lines in one message.
+	maxLogBatch = 100
+	// writeTimeout limits a single websocket write to a stalled browser.
+	writeTimeout = 10 * time.Second
+	// flushTimeout limits the time to write queued messages on close.
+	flushTimeout = 2 * time.Second
+	// replaySize is the number of written messages kept for a replay.
+	replaySize = 2048
+)
+
+// socket is a signaling channel with a single writer goroutine.
+// Signaling messages (SDP, ICE, ...) are always written before log lines,
+// which are batched and dropped when the browser falls behind.
+// Every written message gets a sequence number and is kept for a while,
+// so a websocket that reattaches gets the messages it has missed.
+type socket struct {
+	queue   chan any
+	logs    chan api.Log
+	dropped atomic.Int64
+	closed  atomic.Bool
+	quit    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	// mu guards the websocket writes and the fields below
+	mu   sync.Mutex
+	conn *websocket.Conn
+	seq  uint64
+	sent []frame
+}
+
+// frame is a written message with its sequence number.
+type frame struct {
+	seq  uint64
+	data []byte
+}
+
+func newSocket() *socket {
+	s := socket{
+		queue:   make(chan any, queueSize),
+		logs:    make(chan api.Log, logQueueSize),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.writer()
+	return &s
+}
+
+// attach makes the websocket current and writes into it all
+// the messages after the last one seen by the client.
+func (s *socket) attach(conn *websocket.Conn, last uint64) (replayed int, lost uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+	if len(s.sent) > 0 && s.sent[0].seq > last+1 {
+		lost = s.sent[0].seq - last - 1
+	}
+	for _, f := range s.sent {
+		if f.seq > last {
+			s.writeFrame(f)
+			replayed++
+		}
+	}
+	return replayed, lost
+}
+
+// detach forgets the websocket unless another one is already attached.
+func (s *socket) detach(conn *websocket.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != conn {
+		return false
+	}
+	s.conn = nil
+	return true
+}
+
+// close writes out the queued messages and stops the writer.
+func (s *socket) close() {
+	s.once.Do(func() {
+		s.closed.Store(true)
+		close(s.quit)
+		select {
+		case <-s.stopped:
+		case <-time.After(flushTimeout):
+			log.Printf("error: signal flush timeout")
+		}
+	})
+}
+
+func ended(conn *websocket.Conn, err error) bool {
+	if errors.Is(err, io.EOF) {
+		if err := conn.Close(); err != nil {
+			log.Printf("error: failed signal close, %v", err)
+		}
+		return true
+	}
+	return false
+}
+
+func receive(conn *websocket.Conn, m any) error { return websocket.JSON.Receive(conn, m) }
+
+// send queues a signaling message, waiting if the queue is full.
+func (s *socket) send(m any) error {
+	if s.closed.Load() {
+		return nil
+	}
+	select {
+	case s.queue <- m:
+		return nil
+	case <-s.quit:
+		return nil
+	}
+}
+
+// log queues a log line or drops it if the queue is full.
+func (s *socket) log(l api.Log) {
+	if s.closed.Load() {
+		return
+	}
+	l.Time = time.Now()
+	select {
+	case s.logs <- l:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *socket) writer() {
+	defer close(s.stopped)
+	for {
+		// signaling goes first
+		select {
+		case m := <-s.queue:
+			s.writeMessage(m)
+			continue
+		default:
+		}
+		select {
+		case m := <-s.queue:
+			s.writeMessage(m)
+		case l := <-s.logs:
+			s.writeLogs(l)
+		case <-s.quit:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush writes out everything left in the queues.
+func (s *socket) flush() {
+	for {
+		select {
+		case m := <-s.queue:
+			s.writeMessage(m)
+		case l := <-s.logs:
+			s.writeLogs(l)
+		default:
+			return
+		}
+	}
+}
+
+// writeMessage writes the signaling message,
+// a close goes after the log lines logged before it.
+func (s *socket) writeMessage(m any) {
+	if _, ok := m.(api.Close); ok {
+		for {
+			select {
+			case l := <-s.logs:
+				s.writeLogs(l)
+				continue
+			default:
+			}
+			break
+		}
+	}
+	s.write(m)
+}
+
+// writeLogs writes the line along with the queued ones in one batch.
+func (s *socket) writeLogs(first api.Log) {
+	batch := []api.Log{first}
+	for len(batch) < maxLogBatch {
+		select {
+		case l := <-s.logs:
+			batch = append(batch, l)
+			continue
+		default:
+		}
+		break
+	}
+	if n := s.dropped.Swap(0); n > 0 {
+		batch = append(batch, api.Log{
+			Tag:  "sys",
+			Time: time.Now(),
+			Text: fmt.Sprintf("%d log lines have been dropped, the browser is too slow", n),
+		})
+	}
+	s.write(api.NewLogs(batch))
+}
+
+// write numbers the message, keeps it for replays
+// and sends it into the current websocket if there is one.
+func (s *socket) write(m any) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("error: signal encode, %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	// messages are JSON objects ({"t":...}), so the number goes in front
+	f := frame{seq: s.seq, data: append([]byte(`{"s":`+strconv.FormatUint(s.seq, 10)+`,`), data[1:]...)}
+	if len(s.sent) == replaySize {
+		s.sent = append(s.sent[:0], s.sent[1:]...)
+	}
+	s.sent = append(s.sent, f)
+	s.writeFrame(f)
+}
+
+// writeFrame sends the frame into the current websocket,
+// which is dropped on errors until the client reattaches.
+func (s *socket) writeFrame(f frame) {
+	if s.conn == nil {
+		return
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := websocket.Message.Send(s.conn, string(f.data)); err != nil {
+		log.Printf("error: signal write, %v", err)
+		s.conn = nil
+	}
+}
@@ -0,0 +1,136 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+)
+
+// RFC5780: 7.2.  CHANGE-REQUEST flags
+const (
+	changeIP   = 0x04
+	changePort = 0x02
+)
+
+// Server is an RFC5780-capable STUN server.
+// It listens on two IPs and two ports (four sockets in total) and answers
+// binding requests from the socket selected with the CHANGE-REQUEST attribute.
+type Server struct {
+	// conns are indexed as [ip][port]
+	conns [2][2]*net.UDPConn
+	log   logging.LeveledLogger
+}
+
+var errServerAddress = errors.New("two distinct IPs and ports are required")
+
+// NewServer opens the STUN server sockets, where ips and ports are
+// the primary and alternate ones (i.e. 127.0.0.1, 127.0.0.2 and 3478, 3479).
+func NewServer(ips [2]string, ports [2]int, l logging.LeveledLogger) (*Server, error) {
+	if ips[0] == ips[1] || ports[0] == ports[1] {
+		return nil, errServerAddress
+	}
+	s := Server{log: l}
+	for i, ip := range ips {
+		addr := net.ParseIP(ip)
+		if addr == nil || addr.IsUnspecified() {
+			_ = s.Close()
+			return nil, fmt.Errorf("bad STUN server IP [%v], %w", ip, errServerAddress)
+		}
+		for j, port := range ports {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr, Port: port})
+			if err != nil {
+				_ = s.Close()
+				return nil, err
+			}
+			s.conns[i][j] = conn
+		}
+	}
+	return &s, nil
+}
+
+// Start handles binding requests on all the sockets in the background.
+func (s *Server) Start() {
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			s.log.Infof("STUN server is listening on %v", s.conns[i][j].LocalAddr())
+			go s.serve(i, j)
+		}
+	}
+}
+
+func (s *Server) serve(i, j int) {
+	conn := s.conns[i][j]
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Warnf("STUN server read error: %v", err)
+			}
+			return
+		}
+		if !stun.IsMessage(buf[:n]) {
+			continue
+		}
+		req := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+		if err = req.Decode(); err != nil {
+			s.log.Debugf("STUN server bad message from %v: %v", addr, err)
+			continue
+		}
+		if req.Type != stun.BindingRequest {
+			s.log.Debugf("STUN server skips %v from %v", req.Type, addr)
+			continue
+		}
+		if err = s.respond(req, addr, i, j); err != nil {
+			s.log.Warnf("STUN server response error: %v", err)
+		}
+	}
+}
+
+// respond sends a binding success from the socket
+// requested by the client with the CHANGE-REQUEST flags.
+func (s *Server) respond(req *stun.Message, addr *net.UDPAddr, i, j int) error {
+	if v, err := req.Get(stun.AttrChangeRequest); err == nil && len(v) == 4 {
+		if v[3]&changeIP != 0 {
+			i ^= 1
+		}
+		if v[3]&changePort != 0 {
+			j ^= 1
+		}
+	}
+	from := s.conns[i][j].LocalAddr().(*net.UDPAddr)
+	other := s.conns[i^1][j^1].LocalAddr().(*net.UDPAddr)
+
+	resp, err := stun.Build(
+		stun.NewTransactionIDSetter(req.TransactionID),
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: addr.IP, Port: addr.Port},
+		&stun.MappedAddress{IP: addr.IP, Port: addr.Port},
+		&stun.ResponseOrigin{IP: from.IP, Port: from.Port},
+		&stun.OtherAddress{IP: other.IP, Port: other.Port},
+		stun.NewSoftware("w3t"),
+		stun.Fingerprint,
+	)
+	if err != nil {
+		return err
+	}
+	s.log.Debugf("STUN server binding %v -> %v", from, addr)
+	_, err = s.conns[i][j].WriteToUDP(resp.Raw, addr)
+	return err
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	var err error
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			if s.conns[i][j] != nil {
+				err = errors.Join(err, s.conns[i][j].Close())
+			}
+		}
+	}
+	return err
+}
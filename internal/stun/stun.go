@@ -3,6 +3,7 @@ package stun
 import (
 	"errors"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/pion/logging"
@@ -15,8 +16,14 @@ import (
 // - 4.4.  Determining NAT Filtering Behavior
 
 type (
+	// tester runs the tests with its own log,
+	// so the sessions testing at the same time keep their logs apart.
+	tester struct {
+		log logging.LeveledLogger
+	}
 	stunServerConn struct {
 		conn        net.PacketConn
+		log         logging.LeveledLogger
 		LocalAddr   net.Addr
 		RemoteAddr  *net.UDPAddr
 		OtherAddr   *net.UDPAddr
@@ -29,16 +36,127 @@ type (
 		mappedAddr *stun.MappedAddress
 		software   *stun.Software
 	}
+	// Behavior is a NAT mapping or filtering behavior from RFC4787.
+	Behavior string
+	// Result is the outcome of the RFC5780 tests against one STUN server.
+	Result struct {
+		Server string `json:"server"`
+		// Network is either udp4 or udp6
+		Network string `json:"network"`
+		// NAT64 is set when the server is reached through a NAT64 prefix
+		NAT64        bool     `json:"nat64,omitempty"`
+		Local        string   `json:"local,omitempty"`
+		Mapping      Behavior `json:"mapping"`
+		Filtering    Behavior `json:"filtering"`
+		NAT          string   `json:"nat"`
+		Mapped       []string `json:"mapped,omitempty"`
+		Tests        []Test   `json:"tests"`
+		Inconclusive []string `json:"inconclusive,omitempty"`
+	}
+	// Report is a set of results from several STUN servers
+	// with a verdict for each network.
+	Report struct {
+		Results  []Result  `json:"results"`
+		Verdicts []Verdict `json:"verdicts"`
+	}
+	// Verdict is the consensus of the results of one network.
+	Verdict struct {
+		Network   string   `json:"network"`
+		Mapping   Behavior `json:"mapping"`
+		Filtering Behavior `json:"filtering"`
+		NAT       string   `json:"nat"`
+		// Agreed is the number of servers that support the verdict
+		Agreed int `json:"agreed"`
+		Total  int `json:"total"`
+	}
+	// Test is a single binding request round trip.
+	Test struct {
+		Name   string `json:"name"`
+		Ms     int64  `json:"ms"`
+		Mapped string `json:"mapped,omitempty"`
+		Err    string `json:"err,omitempty"`
+	}
 )
 
+const (
+	Unknown                 Behavior = "unknown"
+	NoNAT                   Behavior = "no NAT"
+	EndpointIndependent     Behavior = "endpoint independent"
+	AddressDependent        Behavior = "address dependent"
+	AddressAndPortDependent Behavior = "address and port dependent"
+)
+
+// Classic returns the classic (RFC3489) name of the NAT type.
+func (r *Result) Classic() string {
+	switch {
+	case r.Mapping == Unknown:
+		return string(Unknown)
+	case r.Mapping == NoNAT && r.Filtering == EndpointIndependent:
+		return "open internet"
+	case r.Mapping == NoNAT:
+		return "firewall"
+	case r.Mapping != EndpointIndependent:
+		return "symmetric"
+	case r.Filtering == EndpointIndependent:
+		return "full-cone"
+	case r.Filtering == AddressDependent:
+		return "restricted-cone"
+	case r.Filtering == AddressAndPortDependent:
+		return "port-restricted-cone"
+	}
+	return string(Unknown)
+}
+
+// track records a binding round trip as a named test
+func (r *Result) track(name string, c *stunServerConn, msg *stun.Message, addr net.Addr) (*stun.Message, error) {
+	start := time.Now()
+	resp, err := c.roundTrip(msg, addr)
+	test := Test{Name: name, Ms: time.Since(start).Milliseconds()}
+	if err != nil {
+		test.Err = err.Error()
+	} else {
+		var xorAddr stun.XORMappedAddress
+		if xorAddr.GetFrom(resp) == nil {
+			test.Mapped = xorAddr.String()
+		}
+	}
+	r.Tests = append(r.Tests, test)
+	return resp, err
+}
+
+// consensus picks the most common verdict of all conclusive results of the network.
+func (r *Report) consensus(network string) Verdict {
+	v := Verdict{Network: network, Mapping: Unknown, Filtering: Unknown, NAT: string(Unknown)}
+	votes := map[string]int{}
+	for _, res := range r.Results {
+		if res.Network != network {
+			continue
+		}
+		v.Total++
+		if res.NAT != string(Unknown) {
+			votes[res.NAT]++
+		}
+	}
+	for _, res := range r.Results {
+		if n := votes[res.NAT]; res.Network == network && n > v.Agreed {
+			v.Agreed = n
+			v.Mapping, v.Filtering, v.NAT = res.Mapping, res.Filtering, res.NAT
+		}
+	}
+	return v
+}
+
+func (r *Result) inconclusive(test string, err error) {
+	r.Inconclusive = append(r.Inconclusive, test+": "+err.Error())
+}
+
 func (c *stunServerConn) Close() error {
 	return c.conn.Close()
 }
 
-var log logging.LeveledLogger
-
 const (
-	stunAddr = "stun.nextcloud.com:443"
+	// DefaultServer is an RFC5780-capable STUN server used by default
+	DefaultServer = "stun.nextcloud.com:443"
 	// the number of seconds to wait for STUN server's response
 	timeout = 3 * time.Second
 )
@@ -47,152 +165,218 @@ var (
 	errResponseMessage = errors.New("error reading from response message channel")
 	errTimedOut        = errors.New("timed out waiting for response")
 	errNoOtherAddress  = errors.New("no OTHER-ADDRESS in message")
+	errNoMappedAddress = errors.New("no XOR-MAPPED-ADDRESS in message")
 )
 
-func Main(l logging.LeveledLogger) {
-	log = l
-	//logging.NewDefaultLeveledLoggerForScope("", logging.LogLevelDebug, os.Stdout)
-	if err := mappingTests(stunAddr); err != nil {
-		log.Warn("NAT mapping behavior: inconclusive")
+// Networks are tested separately, since IPv6 usually goes without NAT
+// or through NAT64 while IPv4 is behind a NAT.
+var Networks = []string{"udp4", "udp6"}
+
+// nat64 is the RFC6052 well-known NAT64 prefix
+var nat64 = net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// Main runs the NAT behavior discovery tests against each of the servers
+// and returns their results along with a consensus verdict.
+func Main(servers []string, log logging.LeveledLogger) Report {
+	t := tester{log: log}
+	var report Report
+	for _, network := range Networks {
+		for _, server := range servers {
+			if server = Address(server); server == "" {
+				continue
+			}
+			report.Results = append(report.Results, t.test(server, network))
+		}
+		v := report.consensus(network)
+		report.Verdicts = append(report.Verdicts, v)
+		log.Warnf("=> NAT type %s (%d/%d servers agree): %s", network, v.Agreed, v.Total, v.NAT)
 	}
-	if err := filteringTests(stunAddr); err != nil {
-		log.Warn("NAT filtering behavior: inconclusive")
+	return report
+}
+
+func (t tester) test(server, network string) Result {
+	res := Result{Server: server, Network: network, Mapping: Unknown, Filtering: Unknown}
+	if err := t.mappingTests(server, &res); err != nil {
+		t.log.Warn("NAT mapping behavior: inconclusive")
+		res.inconclusive("mapping", err)
 	}
+	if err := t.filteringTests(server, &res); err != nil {
+		t.log.Warn("NAT filtering behavior: inconclusive")
+		res.inconclusive("filtering", err)
+	}
+	res.NAT = res.Classic()
+	t.log.Warnf("=> NAT type [%s %s]: %s", network, server, res.NAT)
+	return res
+}
+
+// Address converts stun: URLs and bare hosts into host:port addresses.
+func Address(server string) string {
+	server = strings.TrimSpace(server)
+	server = strings.TrimPrefix(server, "stun:")
+	if server == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(strings.Trim(server, "[]"), "3478")
+	}
+	return server
 }
 
 // RFC5780: 4.3.  Determining NAT Mapping Behavior
-func mappingTests(addrStr string) error {
-	mapTestConn, err := connect(addrStr)
+func (t tester) mappingTests(addrStr string, res *Result) error {
+	mapTestConn, err := t.connect(addrStr, res.Network)
 	if err != nil {
-		log.Warnf("Error creating STUN connection: %s\n", err.Error())
+		t.log.Warnf("Error creating STUN connection: %s\n", err.Error())
 		return err
 	}
+	defer func() { _ = mapTestConn.Close() }()
+	res.Local = mapTestConn.LocalAddr.String()
+	res.NAT64 = nat64.Contains(mapTestConn.RemoteAddr.IP)
 
 	// Test I: Regular binding request
-	log.Info("Mapping Test I: Regular binding request")
+	t.log.Info("Mapping Test I: Regular binding request")
 	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
 
-	resp, err := mapTestConn.roundTrip(request, mapTestConn.RemoteAddr)
+	resp, err := res.track("mapping I", mapTestConn, request, mapTestConn.RemoteAddr)
 	if err != nil {
 		return err
 	}
 
 	// Parse response message for XOR-MAPPED-ADDRESS and make sure OTHER-ADDRESS valid
-	stun1 := parse(resp)
+	stun1 := t.parse(resp)
 	if stun1.xorAddr == nil || stun1.otherAddr == nil {
-		log.Info("Error: NAT discovery feature not supported by this server")
+		t.log.Info("Error: NAT discovery feature not supported by this server")
 		return errNoOtherAddress
 	}
-	addr, err := net.ResolveUDPAddr("udp4", stun1.otherAddr.String())
+	addr, err := net.ResolveUDPAddr(res.Network, stun1.otherAddr.String())
 	if err != nil {
-		log.Infof("Failed resolving OTHER-ADDRESS: %v\n", stun1.otherAddr)
+		t.log.Infof("Failed resolving OTHER-ADDRESS: %v\n", stun1.otherAddr)
 		return err
 	}
 	mapTestConn.OtherAddr = addr
-	log.Infof("Received XOR-MAPPED-ADDRESS: %v\n", stun1.xorAddr)
+	t.log.Infof("Received XOR-MAPPED-ADDRESS: %v\n", stun1.xorAddr)
+	res.Mapped = append(res.Mapped, stun1.xorAddr.String())
 
 	// Assert mapping behavior
 	if stun1.xorAddr.String() == mapTestConn.LocalAddr.String() {
-		log.Warn("=> NAT mapping behavior: endpoint independent (no NAT)")
+		t.log.Warn("=> NAT mapping behavior: endpoint independent (no NAT)")
+		res.Mapping = NoNAT
 		return nil
 	}
 
 	// Test II: Send binding request to the other address but primary port
-	log.Info("Mapping Test II: Send binding request to the other address but primary port")
+	t.log.Info("Mapping Test II: Send binding request to the other address but primary port")
 	otherAddr := *mapTestConn.OtherAddr
 	otherAddr.Port = mapTestConn.RemoteAddr.Port
-	resp, err = mapTestConn.roundTrip(request, &otherAddr)
+	resp, err = res.track("mapping II", mapTestConn, request, &otherAddr)
 	if err != nil {
 		return err
 	}
 
 	// Assert mapping behavior
-	stun2 := parse(resp)
-	log.Infof("Received XOR-MAPPED-ADDRESS: %v\n", stun2.xorAddr)
+	stun2 := t.parse(resp)
+	if stun2.xorAddr == nil {
+		return errNoMappedAddress
+	}
+	t.log.Infof("Received XOR-MAPPED-ADDRESS: %v\n", stun2.xorAddr)
+	res.Mapped = append(res.Mapped, stun2.xorAddr.String())
 	if stun2.xorAddr.String() == stun1.xorAddr.String() {
-		log.Warn("=> NAT mapping behavior: endpoint independent")
+		t.log.Warn("=> NAT mapping behavior: endpoint independent")
+		res.Mapping = EndpointIndependent
 		return nil
 	}
 
 	// Test III: Send binding request to the other address and port
-	log.Info("Mapping Test III: Send binding request to the other address and port")
-	resp, err = mapTestConn.roundTrip(request, mapTestConn.OtherAddr)
+	t.log.Info("Mapping Test III: Send binding request to the other address and port")
+	resp, err = res.track("mapping III", mapTestConn, request, mapTestConn.OtherAddr)
 	if err != nil {
 		return err
 	}
 
 	// Assert mapping behavior
-	stun3 := parse(resp)
-	log.Infof("Received XOR-MAPPED-ADDRESS: %v\n", stun3.xorAddr)
+	stun3 := t.parse(resp)
+	if stun3.xorAddr == nil {
+		return errNoMappedAddress
+	}
+	t.log.Infof("Received XOR-MAPPED-ADDRESS: %v\n", stun3.xorAddr)
+	res.Mapped = append(res.Mapped, stun3.xorAddr.String())
 	if stun3.xorAddr.String() == stun2.xorAddr.String() {
-		log.Warn("=> NAT mapping behavior: address dependent")
+		t.log.Warn("=> NAT mapping behavior: address dependent")
+		res.Mapping = AddressDependent
 	} else {
-		log.Warn("=> NAT mapping behavior: address and port dependent")
+		t.log.Warn("=> NAT mapping behavior: address and port dependent")
+		res.Mapping = AddressAndPortDependent
 	}
 
-	return mapTestConn.Close()
+	return nil
 }
 
 // RFC5780: 4.4.  Determining NAT Filtering Behavior
-func filteringTests(addrStr string) error {
-	mapTestConn, err := connect(addrStr)
+func (t tester) filteringTests(addrStr string, res *Result) error {
+	mapTestConn, err := t.connect(addrStr, res.Network)
 	if err != nil {
-		log.Warnf("Error creating STUN connection: %s\n", err.Error())
+		t.log.Warnf("Error creating STUN connection: %s\n", err.Error())
 		return err
 	}
+	defer func() { _ = mapTestConn.Close() }()
 
 	// Test I: Regular binding request
-	log.Info("Filtering Test I: Regular binding request")
+	t.log.Info("Filtering Test I: Regular binding request")
 	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
 
-	resp, err := mapTestConn.roundTrip(request, mapTestConn.RemoteAddr)
+	resp, err := res.track("filtering I", mapTestConn, request, mapTestConn.RemoteAddr)
 	if err != nil || errors.Is(err, errTimedOut) {
 		return err
 	}
-	stun0 := parse(resp)
+	stun0 := t.parse(resp)
 	if stun0.xorAddr == nil || stun0.otherAddr == nil {
-		log.Warn("Error: NAT discovery feature not supported by this server")
+		t.log.Warn("Error: NAT discovery feature not supported by this server")
 		return errNoOtherAddress
 	}
-	addr, err := net.ResolveUDPAddr("udp4", stun0.otherAddr.String())
+	addr, err := net.ResolveUDPAddr(res.Network, stun0.otherAddr.String())
 	if err != nil {
-		log.Infof("Failed resolving OTHER-ADDRESS: %v\n", stun0.otherAddr)
+		t.log.Infof("Failed resolving OTHER-ADDRESS: %v\n", stun0.otherAddr)
 		return err
 	}
 	mapTestConn.OtherAddr = addr
 
 	// Test II: Request to change both IP and port
-	log.Info("Filtering Test II: Request to change both IP and port")
+	t.log.Info("Filtering Test II: Request to change both IP and port")
 	request = stun.MustBuild(stun.TransactionID, stun.BindingRequest)
 	request.Add(stun.AttrChangeRequest, []byte{0x00, 0x00, 0x00, 0x06})
 
-	resp, err = mapTestConn.roundTrip(request, mapTestConn.RemoteAddr)
+	resp, err = res.track("filtering II", mapTestConn, request, mapTestConn.RemoteAddr)
 	if err == nil {
-		parse(resp) // just to print out the resp
-		log.Warn("=> NAT filtering behavior: endpoint independent")
+		t.parse(resp) // just to print out the resp
+		t.log.Warn("=> NAT filtering behavior: endpoint independent")
+		res.Filtering = EndpointIndependent
 		return nil
 	} else if !errors.Is(err, errTimedOut) {
 		return err // something else went wrong
 	}
 
 	// Test III: Request to change port only
-	log.Info("Filtering Test III: Request to change port only")
+	t.log.Info("Filtering Test III: Request to change port only")
 	request = stun.MustBuild(stun.TransactionID, stun.BindingRequest)
 	request.Add(stun.AttrChangeRequest, []byte{0x00, 0x00, 0x00, 0x02})
 
-	resp, err = mapTestConn.roundTrip(request, mapTestConn.RemoteAddr)
+	resp, err = res.track("filtering III", mapTestConn, request, mapTestConn.RemoteAddr)
 	if err == nil {
-		parse(resp) // just to print out the resp
-		log.Warn("=> NAT filtering behavior: address dependent")
+		t.parse(resp) // just to print out the resp
+		t.log.Warn("=> NAT filtering behavior: address dependent")
+		res.Filtering = AddressDependent
 	} else if errors.Is(err, errTimedOut) {
-		log.Warn("=> NAT filtering behavior: address and port dependent")
+		t.log.Warn("=> NAT filtering behavior: address and port dependent")
+		res.Filtering = AddressAndPortDependent
+	} else {
+		return err
 	}
 
-	return mapTestConn.Close()
+	return nil
 }
 
 // Parse a STUN message
-func parse(msg *stun.Message) (ret stunData) {
+func (t tester) parse(msg *stun.Message) (ret stunData) {
 	ret.mappedAddr = &stun.MappedAddress{}
 	ret.xorAddr = &stun.XORMappedAddress{}
 	ret.respOrigin = &stun.ResponseOrigin{}
@@ -213,7 +397,7 @@ func parse(msg *stun.Message) (ret stunData) {
 	if ret.software.GetFrom(msg) != nil {
 		ret.software = nil
 	}
-	log.Debugf(
+	t.log.Debugf(
 		"%v\n"+
 			"\tMAPPED-ADDRESS:     %v\n"+
 			"\tXOR-MAPPED-ADDRESS: %v\n"+
@@ -231,49 +415,63 @@ func parse(msg *stun.Message) (ret stunData) {
 			stun.AttrSoftware:
 			break
 		default:
-			log.Debugf("\t%v (l=%v)\n", attr, attr.Length)
+			t.log.Debugf("\t%v (l=%v)\n", attr, attr.Length)
 		}
 	}
 	return ret
 }
 
-// Given an address string, returns a StunServerConn
-func connect(addrStr string) (*stunServerConn, error) {
-	log.Infof("connecting to STUN server: %s\n", addrStr)
-	addr, err := net.ResolveUDPAddr("udp4", addrStr)
+// Given an address string and a network (udp4, udp6), returns a StunServerConn
+func (t tester) connect(addrStr, network string) (*stunServerConn, error) {
+	t.log.Infof("connecting to STUN server: %s (%s)\n", addrStr, network)
+	addr, err := net.ResolveUDPAddr(network, addrStr)
 	if err != nil {
-		log.Warnf("Error resolving address: %s\n", err.Error())
+		t.log.Warnf("Error resolving address: %s\n", err.Error())
 		return nil, err
 	}
 
-	c, err := net.ListenUDP("udp4", nil)
+	c, err := net.ListenUDP(network, nil)
 	if err != nil {
 		return nil, err
 	}
-	log.Infof("Local address: %s\n", c.LocalAddr())
-	log.Infof("Remote address: %s\n", addr.String())
+	local := localAddr(c, addr)
+	t.log.Infof("Local address: %s\n", local)
+	t.log.Infof("Remote address: %s\n", addr.String())
 
-	mChan := listen(c)
+	mChan := listen(c, t.log)
 
 	return &stunServerConn{
 		conn:        c,
-		LocalAddr:   c.LocalAddr(),
+		log:         t.log,
+		LocalAddr:   local,
 		RemoteAddr:  addr,
 		messageChan: mChan,
 	}, nil
 }
 
+// localAddr replaces the unspecified IP of the socket with
+// the IP that the system routes to the remote address from,
+// so it can be compared with the mapped address.
+func localAddr(c *net.UDPConn, remote *net.UDPAddr) *net.UDPAddr {
+	local := *c.LocalAddr().(*net.UDPAddr)
+	if probe, err := net.DialUDP(c.LocalAddr().Network(), nil, remote); err == nil {
+		local.IP = probe.LocalAddr().(*net.UDPAddr).IP
+		_ = probe.Close()
+	}
+	return &local
+}
+
 // Send request and wait for response or timeout
 func (c *stunServerConn) roundTrip(msg *stun.Message, addr net.Addr) (*stun.Message, error) {
 	_ = msg.NewTransactionID()
-	log.Infof("Sending to %v: (%v bytes)\n", addr, msg.Length+20)
-	log.Debugf("%v\n", msg)
+	c.log.Infof("Sending to %v: (%v bytes)\n", addr, msg.Length+20)
+	c.log.Debugf("%v\n", msg)
 	for _, attr := range msg.Attributes {
-		log.Debugf("\t%v (l=%v)\n", attr, attr.Length)
+		c.log.Debugf("\t%v (l=%v)\n", attr, attr.Length)
 	}
 	_, err := c.conn.WriteTo(msg.Raw, addr)
 	if err != nil {
-		log.Warnf("Error sending request to %v\n", addr)
+		c.log.Warnf("Error sending request to %v\n", addr)
 		return nil, err
 	}
 
@@ -285,12 +483,12 @@ func (c *stunServerConn) roundTrip(msg *stun.Message, addr net.Addr) (*stun.Mess
 		}
 		return m, nil
 	case <-time.After(timeout):
-		log.Infof("Timed out waiting for response from server %v\n", addr)
+		c.log.Infof("Timed out waiting for response from server %v\n", addr)
 		return nil, errTimedOut
 	}
 }
 
-func listen(conn *net.UDPConn) chan *stun.Message {
+func listen(conn *net.UDPConn, log logging.LeveledLogger) chan *stun.Message {
 	mess := make(chan *stun.Message)
 	go func() {
 		defer close(mess)
@@ -0,0 +1,351 @@
+package stun
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+)
+
+func discard() logging.LeveledLogger {
+	return logging.NewDefaultLeveledLoggerForScope("stun", logging.LogLevelDisabled, io.Discard)
+}
+
+// lockedBuffer is a log writer for the loggers used from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startServer runs a local RFC5780 server on 127.0.0.1 and 127.0.0.2
+// and returns its primary address.
+func startServer(t *testing.T) string {
+	t.Helper()
+	// two distinct free ports, the sockets are held until both are known
+	var ports [2]int
+	var conns [2]*net.UDPConn
+	for i := range conns {
+		c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i], ports[i] = c, c.LocalAddr().(*net.UDPAddr).Port
+	}
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	s, err := NewServer([2]string{"127.0.0.1", "127.0.0.2"}, ports, discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	s.Start()
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0]))
+}
+
+// natPort is the first port the emulated NAT maps the client to.
+const natPort = 50000
+
+// startNAT runs an RFC5780 server that answers as if the client were behind
+// a NAT of the behaviors: it rewrites the mapped address the way the NAT maps
+// the client and drops the responses the NAT filter wouldn't let in.
+// It returns the primary address.
+func startNAT(t *testing.T, mapping, filtering Behavior) string {
+	t.Helper()
+	ips := [2]net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+	// the [ip][port] of the sockets the mapping tests I, II and III go to
+	at := [][2]int{{0, 0}, {1, 0}, {1, 1}}
+	var ports [2]int
+	var conns []*net.UDPConn
+	for _, a := range at {
+		c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ips[a[0]], Port: ports[a[1]]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		ports[a[1]] = c.LocalAddr().(*net.UDPAddr).Port
+		conns = append(conns, c)
+	}
+	other := &stun.OtherAddress{IP: ips[1], Port: ports[1]}
+
+	for k, c := range conns {
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				n, addr, err := c.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				req := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+				if req.Decode() != nil {
+					continue
+				}
+				// the response comes from the requested socket only in the NAT view,
+				// since the client doesn't check where it comes from
+				if v, err := req.Get(stun.AttrChangeRequest); err == nil && len(v) == 4 {
+					newIP, newPort := v[3]&changeIP != 0, v[3]&changePort != 0
+					if newIP && filtering != EndpointIndependent || newPort && filtering == AddressAndPortDependent {
+						continue
+					}
+				}
+				mapped := stun.XORMappedAddress{IP: net.IPv4(203, 0, 113, 1), Port: natPort}
+				switch ip, port := at[k][0], at[k][1]; mapping {
+				case NoNAT:
+					mapped.IP, mapped.Port = addr.IP, addr.Port
+				case AddressDependent:
+					mapped.Port += ip
+				case AddressAndPortDependent:
+					mapped.Port += 2*ip + port
+				}
+				resp, err := stun.Build(stun.NewTransactionIDSetter(req.TransactionID), stun.BindingSuccess, &mapped, other)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, _ = c.WriteToUDP(resp.Raw, addr)
+			}
+		}()
+	}
+	return net.JoinHostPort(ips[0].String(), strconv.Itoa(ports[0]))
+}
+
+func TestNATBehavior(t *testing.T) {
+	tests := []struct {
+		mapping, filtering Behavior
+		nat                string
+		tests              string
+	}{
+		{NoNAT, EndpointIndependent, "open internet", "mapping I,filtering I,filtering II"},
+		{NoNAT, AddressAndPortDependent, "firewall", "mapping I,filtering I,filtering II,filtering III"},
+		{EndpointIndependent, EndpointIndependent, "full-cone", "mapping I,mapping II,filtering I,filtering II"},
+		{EndpointIndependent, AddressDependent, "restricted-cone",
+			"mapping I,mapping II,filtering I,filtering II,filtering III"},
+		{EndpointIndependent, AddressAndPortDependent, "port-restricted-cone",
+			"mapping I,mapping II,filtering I,filtering II,filtering III"},
+		{AddressDependent, EndpointIndependent, "symmetric", "mapping I,mapping II,mapping III,filtering I,filtering II"},
+		{AddressAndPortDependent, AddressDependent, "symmetric",
+			"mapping I,mapping II,mapping III,filtering I,filtering II,filtering III"},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.mapping)+"/"+string(tc.filtering), func(t *testing.T) {
+			t.Parallel()
+			server := startNAT(t, tc.mapping, tc.filtering)
+
+			res := tester{log: discard()}.test(server, "udp4")
+
+			if res.Mapping != tc.mapping || res.Filtering != tc.filtering || res.NAT != tc.nat {
+				t.Errorf("got %v/%v (%v), want %v/%v (%v)",
+					res.Mapping, res.Filtering, res.NAT, tc.mapping, tc.filtering, tc.nat)
+			}
+			if len(res.Inconclusive) > 0 {
+				t.Errorf("got inconclusive %v", res.Inconclusive)
+			}
+			var names []string
+			for _, test := range res.Tests {
+				names = append(names, test.Name)
+			}
+			if got := strings.Join(names, ","); got != tc.tests {
+				t.Errorf("got tests %v, want %v", got, tc.tests)
+			}
+			if got, want := len(res.Mapped), strings.Count(tc.tests, "mapping"); got != want {
+				t.Errorf("got mapped %v, want %v addresses", res.Mapped, want)
+			}
+		})
+	}
+}
+
+func TestMainLocalServer(t *testing.T) {
+	server := startServer(t)
+
+	report := Main([]string{"stun:" + server}, discard())
+
+	if len(report.Results) != len(Networks) {
+		t.Fatalf("got %v results, want %v", len(report.Results), len(Networks))
+	}
+	res := report.Results[0]
+	if res.Server != server || res.Network != "udp4" {
+		t.Errorf("got %v %v result, want %v udp4", res.Server, res.Network, server)
+	}
+	if res.Mapping != NoNAT || res.Filtering != EndpointIndependent || res.NAT != "open internet" {
+		t.Errorf("got %v/%v (%v), want no NAT/endpoint independent (open internet)", res.Mapping, res.Filtering, res.NAT)
+	}
+	if len(res.Inconclusive) > 0 {
+		t.Errorf("got inconclusive %v", res.Inconclusive)
+	}
+	if len(res.Mapped) != 1 || res.Mapped[0] != res.Local {
+		t.Errorf("got mapped %v, want the local address %v", res.Mapped, res.Local)
+	}
+	var names []string
+	for _, test := range res.Tests {
+		if test.Err != "" {
+			t.Errorf("test %v err: %v", test.Name, test.Err)
+		}
+		names = append(names, test.Name)
+	}
+	if got, want := strings.Join(names, ","), "mapping I,filtering I,filtering II"; got != want {
+		t.Errorf("got tests %v, want %v", got, want)
+	}
+
+	// the IPv4 server can't be reached over IPv6
+	if v6 := report.Results[1]; v6.Network != "udp6" || v6.NAT != string(Unknown) || len(v6.Inconclusive) == 0 {
+		t.Errorf("got udp6 %v (inconclusive %v), want an inconclusive unknown", v6.NAT, v6.Inconclusive)
+	}
+
+	want := []Verdict{
+		{Network: "udp4", Mapping: NoNAT, Filtering: EndpointIndependent, NAT: "open internet", Agreed: 1, Total: 1},
+		{Network: "udp6", Mapping: Unknown, Filtering: Unknown, NAT: string(Unknown), Agreed: 0, Total: 1},
+	}
+	if len(report.Verdicts) != len(want) {
+		t.Fatalf("got verdicts %+v, want %+v", report.Verdicts, want)
+	}
+	for i := range want {
+		if report.Verdicts[i] != want[i] {
+			t.Errorf("got verdict %+v, want %+v", report.Verdicts[i], want[i])
+		}
+	}
+}
+
+func TestMainSeparateLogs(t *testing.T) {
+	servers := []string{startServer(t), startServer(t)}
+	logs := []*lockedBuffer{{}, {}}
+
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Go(func() {
+			Main(servers[i:i+1], logging.NewDefaultLeveledLoggerForScope("stun", logging.LogLevelInfo, logs[i]))
+		})
+	}
+	wg.Wait()
+
+	for i := range servers {
+		log, other := logs[i].String(), servers[1-i]
+		if !strings.Contains(log, servers[i]) {
+			t.Errorf("log %v has no lines of its server %v", i, servers[i])
+		}
+		if strings.Contains(log, other) {
+			t.Errorf("log %v has the lines of the other server %v", i, other)
+		}
+	}
+}
+
+func TestClassic(t *testing.T) {
+	tests := []struct {
+		mapping, filtering Behavior
+		want               string
+	}{
+		{Unknown, EndpointIndependent, "unknown"},
+		{NoNAT, EndpointIndependent, "open internet"},
+		{NoNAT, AddressAndPortDependent, "firewall"},
+		{NoNAT, Unknown, "firewall"},
+		{AddressDependent, EndpointIndependent, "symmetric"},
+		{AddressAndPortDependent, Unknown, "symmetric"},
+		{EndpointIndependent, EndpointIndependent, "full-cone"},
+		{EndpointIndependent, AddressDependent, "restricted-cone"},
+		{EndpointIndependent, AddressAndPortDependent, "port-restricted-cone"},
+		{EndpointIndependent, Unknown, "unknown"},
+	}
+	for _, test := range tests {
+		r := Result{Mapping: test.mapping, Filtering: test.filtering}
+		if got := r.Classic(); got != test.want {
+			t.Errorf("%v/%v: got %v, want %v", test.mapping, test.filtering, got, test.want)
+		}
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	result := func(network string, mapping, filtering Behavior) Result {
+		r := Result{Network: network, Mapping: mapping, Filtering: filtering}
+		r.NAT = r.Classic()
+		return r
+	}
+	tests := []struct {
+		name    string
+		results []Result
+		want    Verdict
+	}{
+		{
+			name: "no results",
+			want: Verdict{Network: "udp4", Mapping: Unknown, Filtering: Unknown, NAT: "unknown"},
+		},
+		{
+			name: "majority",
+			results: []Result{
+				result("udp4", EndpointIndependent, AddressAndPortDependent),
+				result("udp4", AddressAndPortDependent, AddressAndPortDependent),
+				result("udp4", EndpointIndependent, AddressAndPortDependent),
+			},
+			want: Verdict{
+				Network: "udp4", Mapping: EndpointIndependent, Filtering: AddressAndPortDependent,
+				NAT: "port-restricted-cone", Agreed: 2, Total: 3,
+			},
+		},
+		{
+			name: "inconclusive results don't vote",
+			results: []Result{
+				result("udp4", Unknown, Unknown),
+				result("udp4", Unknown, Unknown),
+				result("udp4", NoNAT, EndpointIndependent),
+			},
+			want: Verdict{
+				Network: "udp4", Mapping: NoNAT, Filtering: EndpointIndependent,
+				NAT: "open internet", Agreed: 1, Total: 3,
+			},
+		},
+		{
+			name: "other networks are skipped",
+			results: []Result{
+				result("udp6", NoNAT, EndpointIndependent),
+				result("udp6", NoNAT, EndpointIndependent),
+				result("udp4", AddressDependent, AddressDependent),
+			},
+			want: Verdict{
+				Network: "udp4", Mapping: AddressDependent, Filtering: AddressDependent,
+				NAT: "symmetric", Agreed: 1, Total: 1,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := Report{Results: test.results}
+			if got := r.consensus("udp4"); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewServerAddresses(t *testing.T) {
+	tests := []struct {
+		ips   [2]string
+		ports [2]int
+	}{
+		{[2]string{"127.0.0.1", "127.0.0.1"}, [2]int{3478, 3479}},
+		{[2]string{"127.0.0.1", "127.0.0.2"}, [2]int{3478, 3478}},
+		{[2]string{"127.0.0.1", "0.0.0.0"}, [2]int{3478, 3479}},
+		{[2]string{"127.0.0.1", "localhost"}, [2]int{3478, 3479}},
+	}
+	for _, test := range tests {
+		if s, err := NewServer(test.ips, test.ports, discard()); err == nil {
+			_ = s.Close()
+			t.Errorf("%v %v: no error", test.ips, test.ports)
+		}
+	}
+}
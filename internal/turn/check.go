@@ -0,0 +1,306 @@
+package turn
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v4"
+)
+
+// Check is the result of a TURN allocation attempt.
+type Check struct {
+	URL      string `json:"url"`
+	Server   string `json:"server,omitempty"`
+	Relayed  string `json:"relayed,omitempty"`
+	Mapped   string `json:"mapped,omitempty"`
+	Lifetime int    `json:"lifetime,omitempty"`
+	Ms       int64  `json:"ms"`
+	Code     int    `json:"code,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Err      string `json:"err,omitempty"`
+}
+
+// Check failure reasons.
+const (
+	ReasonDNS              = "dns"
+	ReasonTimeout          = "timeout"
+	ReasonUnreachable      = "unreachable"
+	ReasonBadRequest       = "bad request"
+	ReasonNoCredentials    = "no credentials"
+	ReasonUnauthorized     = "unauthorized"
+	ReasonStaleNonce       = "stale nonce"
+	ReasonWrongCredentials = "wrong credentials"
+	ReasonQuota            = "quota reached"
+	ReasonError            = "error"
+)
+
+const (
+	// RFC5766: 14.7.  REQUESTED-TRANSPORT, UDP
+	transportUDP = 17
+	// the interval of UDP request retransmissions
+	rto = 500 * time.Millisecond
+)
+
+var (
+	errTimeout  = errors.New("timed out waiting for response")
+	errResponse = errors.New("unexpected response")
+)
+
+type errCode struct{ stun.ErrorCodeAttribute }
+
+func (e errCode) Error() string {
+	if len(e.Reason) == 0 {
+		return fmt.Sprintf("error code %d", e.Code)
+	}
+	return e.ErrorCodeAttribute.String()
+}
+
+// CheckServers makes an Allocate request to each TURN URL of the servers.
+func CheckServers(servers []webrtc.ICEServer, timeout time.Duration) []Check {
+	var checks []Check
+	for _, s := range servers {
+		credential, _ := s.Credential.(string)
+		for _, url := range s.URLs {
+			uri, err := stun.ParseURI(url)
+			if err != nil {
+				checks = append(checks, Check{URL: url, Reason: ReasonError, Err: err.Error()})
+				continue
+			}
+			if uri.Scheme != stun.SchemeTypeTURN && uri.Scheme != stun.SchemeTypeTURNS {
+				continue
+			}
+			checks = append(checks, CheckServer(uri, s.Username, credential, timeout))
+		}
+	}
+	return checks
+}
+
+// CheckServer allocates a relay on the TURN server and releases it right away.
+func CheckServer(uri *stun.URI, username, password string, timeout time.Duration) (check Check) {
+	start := time.Now()
+	check.URL = uri.String()
+	defer func() { check.Ms = time.Since(start).Milliseconds() }()
+
+	fail := func(err error) Check {
+		check.Err = err.Error()
+		check.Reason = reason(err, username != "")
+		var code errCode
+		if errors.As(err, &code) {
+			check.Code = int(code.Code)
+		}
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, uri.Host)
+	if err != nil {
+		return fail(err)
+	}
+	addr := net.JoinHostPort(ips[0].IP.String(), strconv.Itoa(uri.Port))
+	check.Server = addr
+
+	conn, err := dial(ctx, uri, addr)
+	if err != nil {
+		return fail(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	c := client{
+		Conn:  conn,
+		udp:   uri.Proto == stun.ProtoTypeUDP && uri.Scheme == stun.SchemeTypeTURN,
+		until: start.Add(timeout),
+	}
+
+	// the first request is expected to be rejected with REALM and NONCE
+	resp, err := c.roundTrip(stun.MustBuild(stun.TransactionID,
+		stun.NewType(stun.MethodAllocate, stun.ClassRequest), requestedTransport, stun.Fingerprint))
+	if err == nil {
+		return fail(errResponse)
+	}
+	var code errCode
+	if !errors.As(err, &code) || code.Code != stun.CodeUnauthorized {
+		return fail(err)
+	}
+	if username == "" {
+		return fail(err)
+	}
+
+	var realm stun.Realm
+	var nonce stun.Nonce
+	var integrity stun.MessageIntegrity
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = realm.GetFrom(resp); err != nil {
+			return fail(err)
+		}
+		if err = nonce.GetFrom(resp); err != nil {
+			return fail(err)
+		}
+		integrity = stun.NewLongTermIntegrity(username, realm.String(), password)
+		resp, err = c.roundTrip(stun.MustBuild(stun.TransactionID,
+			stun.NewType(stun.MethodAllocate, stun.ClassRequest), requestedTransport,
+			stun.NewUsername(username), realm, nonce, integrity, stun.Fingerprint))
+		// the nonce can be stale, so retry once with the new one
+		if errors.As(err, &code) && code.Code == stun.CodeStaleNonce && attempt == 0 {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	var relayed, mapped stun.XORMappedAddress
+	if err = relayed.GetFromAs(resp, stun.AttrXORRelayedAddress); err == nil {
+		check.Relayed = relayed.String()
+	}
+	if err = mapped.GetFrom(resp); err == nil {
+		check.Mapped = mapped.String()
+	}
+	if v, err := resp.Get(stun.AttrLifetime); err == nil && len(v) == 4 {
+		check.Lifetime = int(binary.BigEndian.Uint32(v))
+	}
+
+	// release the allocation
+	_, _ = c.roundTrip(stun.MustBuild(stun.TransactionID,
+		stun.NewType(stun.MethodRefresh, stun.ClassRequest), lifetime(0),
+		stun.NewUsername(username), realm, nonce, integrity, stun.Fingerprint))
+
+	return check
+}
+
+func reason(err error, credentials bool) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var code errCode
+	switch {
+	case errors.As(err, &dnsErr):
+		return ReasonDNS
+	case errors.Is(err, errTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout
+	case errors.As(err, &code):
+		switch code.Code {
+		// some servers (i.e. Pion) answer with it on a wrong MESSAGE-INTEGRITY
+		case stun.CodeBadRequest:
+			return ReasonBadRequest
+		case stun.CodeUnauthorized:
+			if !credentials {
+				return ReasonNoCredentials
+			}
+			return ReasonUnauthorized
+		case stun.CodeStaleNonce:
+			return ReasonStaleNonce
+		case stun.CodeWrongCredentials:
+			return ReasonWrongCredentials
+		case stun.CodeAllocQuotaReached:
+			return ReasonQuota
+		}
+	case errors.As(err, new(*net.OpError)):
+		return ReasonUnreachable
+	}
+	return ReasonError
+}
+
+func dial(ctx context.Context, uri *stun.URI, addr string) (net.Conn, error) {
+	switch {
+	case uri.Scheme == stun.SchemeTypeTURNS:
+		d := tls.Dialer{Config: &tls.Config{ServerName: uri.Host}}
+		return d.DialContext(ctx, "tcp", addr)
+	case uri.Proto == stun.ProtoTypeTCP:
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	default:
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", addr)
+	}
+}
+
+var requestedTransport = stun.RawAttribute{
+	Type:  stun.AttrRequestedTransport,
+	Value: []byte{transportUDP, 0, 0, 0},
+}
+
+func lifetime(seconds uint32) stun.RawAttribute {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, seconds)
+	return stun.RawAttribute{Type: stun.AttrLifetime, Value: v}
+}
+
+// client is a minimal STUN transaction client over UDP or stream connections.
+type client struct {
+	net.Conn
+	udp   bool
+	until time.Time
+}
+
+func (c *client) roundTrip(req *stun.Message) (*stun.Message, error) {
+	for {
+		deadline := c.until
+		// retransmit lost UDP requests until the deadline
+		if next := time.Now().Add(rto); c.udp && next.Before(deadline) {
+			deadline = next
+		}
+		_ = c.SetDeadline(deadline)
+		if _, err := c.Write(req.Raw); err != nil {
+			return nil, err
+		}
+		resp, err := c.read(req.TransactionID)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			if c.udp && time.Now().Before(c.until) {
+				continue
+			}
+			return nil, errTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.Type.Class == stun.ClassErrorResponse {
+			var code stun.ErrorCodeAttribute
+			if err = code.GetFrom(resp); err != nil {
+				return nil, err
+			}
+			return resp, errCode{code}
+		}
+		return resp, nil
+	}
+}
+
+func (c *client) read(id [stun.TransactionIDSize]byte) (*stun.Message, error) {
+	buf := make([]byte, 1500)
+	for {
+		var n int
+		var err error
+		if c.udp {
+			n, err = c.Read(buf)
+		} else {
+			// STUN over streams: a 20-byte header with the attributes length
+			if _, err = io.ReadFull(c, buf[:20]); err == nil {
+				size := 20 + int(binary.BigEndian.Uint16(buf[2:4]))
+				if size > len(buf) {
+					return nil, fmt.Errorf("too big STUN message (%d)", size)
+				}
+				_, err = io.ReadFull(c, buf[20:size])
+				n = size
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+		if err = m.Decode(); err != nil || m.TransactionID != id {
+			continue
+		}
+		return m, nil
+	}
+}
@@ -0,0 +1,96 @@
+package turn
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v5"
+	"github.com/pion/webrtc/v4"
+)
+
+// Server is an embedded TURN relay that accepts
+// time-limited TURN REST API credentials.
+type Server struct {
+	*turn.Server
+	conf Config
+}
+
+// Config is a TURN server configuration.
+type Config struct {
+	// Addr is a UDP and TCP listen address, i.e. :3478
+	Addr string
+	// PublicIP is the relay address given to clients
+	PublicIP string
+	Realm    string
+	// Secret is a shared secret for the credentials, generated if empty
+	Secret string
+	// TTL is a lifetime of the issued credentials
+	TTL time.Duration
+}
+
+var errPublicIP = errors.New("a valid TURN public IP is required")
+
+func NewServer(conf Config, logger logging.LoggerFactory) (*Server, error) {
+	ip := net.ParseIP(conf.PublicIP)
+	if ip == nil {
+		return nil, errPublicIP
+	}
+	if conf.Secret == "" {
+		conf.Secret = rand.Text()
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = time.Hour
+	}
+
+	udp, err := net.ListenPacket("udp4", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp4", conf.Addr)
+	if err != nil {
+		_ = udp.Close()
+		return nil, err
+	}
+	gen := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorStatic{RelayAddress: ip, Address: "0.0.0.0"}
+	}
+
+	s, err := turn.NewServer(turn.ServerConfig{
+		Realm:             conf.Realm,
+		AuthHandler:       turn.LongTermTURNRESTAuthHandler(conf.Secret, logger.NewLogger("turn")),
+		LoggerFactory:     logger,
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udp, RelayAddressGenerator: gen()}},
+		ListenerConfigs:   []turn.ListenerConfig{{Listener: tcp, RelayAddressGenerator: gen()}},
+	})
+	if err != nil {
+		_ = udp.Close()
+		_ = tcp.Close()
+		return nil, err
+	}
+	conf.Addr = net.JoinHostPort(conf.PublicIP, strconv.Itoa(udp.LocalAddr().(*net.UDPAddr).Port))
+	return &Server{Server: s, conf: conf}, nil
+}
+
+// ICEServer issues TURN REST credentials for the user (session)
+// valid for the configured TTL.
+func (s *Server) ICEServer(user string) (webrtc.ICEServer, error) {
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(s.conf.Secret, user, s.conf.TTL)
+	if err != nil {
+		return webrtc.ICEServer{}, err
+	}
+	return webrtc.ICEServer{
+		URLs: []string{
+			fmt.Sprintf("turn:%s?transport=udp", s.conf.Addr),
+			fmt.Sprintf("turn:%s?transport=tcp", s.conf.Addr),
+		},
+		Username:   username,
+		Credential: password,
+	}, nil
+}
+
+func (s *Server) String() string { return s.conf.Addr }
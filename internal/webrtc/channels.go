@@ -0,0 +1,184 @@
+package webrtc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// SequencePrefix starts the labels of the sequence test channels.
+	SequencePrefix = "seq "
+	// sequenceCount is the number of messages a side sends into a channel.
+	sequenceCount = 500
+	// sequenceInterval is the time between the messages.
+	sequenceInterval = 10 * time.Millisecond
+	// sequenceSize is the size of a message.
+	sequenceSize = 1024
+	// sequenceWait is the time to wait for the late messages.
+	sequenceWait = 3 * time.Second
+)
+
+// ChannelSpec is a data channel with the reliability settings,
+// written as colon-separated options: reliable (the default), unordered,
+// rtx=N (max retransmits), life=MS (max packet lifetime), id=N (pre-negotiated id).
+// I.e. unordered:rtx=0 or id=10:life=100.
+type ChannelSpec struct {
+	Label string
+	Init  webrtc.DataChannelInit
+}
+
+// ParseChannels reads a comma-separated list of channel specs.
+func ParseChannels(v string) ([]ChannelSpec, error) {
+	var specs []ChannelSpec
+	ids := map[uint16]bool{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		spec := ChannelSpec{Label: SequencePrefix + s}
+		for _, opt := range strings.Split(s, ":") {
+			name, value, _ := strings.Cut(opt, "=")
+			switch name {
+			case "unordered":
+				ordered := false
+				spec.Init.Ordered = &ordered
+				continue
+			case "reliable":
+				continue
+			case "rtx", "life", "id":
+			default:
+				return nil, fmt.Errorf("unknown channel %v option [%v]", s, opt)
+			}
+			n, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("bad channel %v option [%v]", s, opt)
+			}
+			v := uint16(n)
+			switch name {
+			case "rtx":
+				spec.Init.MaxRetransmits = &v
+			case "life":
+				spec.Init.MaxPacketLifeTime = &v
+			case "id":
+				if v == 65535 || ids[v] {
+					return nil, fmt.Errorf("bad channel %v id, it should be unique and less than 65535", s)
+				}
+				ids[v] = true
+				negotiated := true
+				spec.Init.Negotiated, spec.Init.ID = &negotiated, &v
+			}
+		}
+		if spec.Init.MaxRetransmits != nil && spec.Init.MaxPacketLifeTime != nil {
+			return nil, fmt.Errorf("channel %v may have either rtx or life", s)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// SequenceStats is what one side has got from the other in a channel.
+type SequenceStats struct {
+	Label      string
+	Expected   int
+	Received   int
+	Duplicates int
+	Reordered  int
+}
+
+func (s SequenceStats) String() string {
+	lost := s.Expected - s.Received
+	loss := 0.0
+	if s.Expected > 0 {
+		loss = float64(lost) / float64(s.Expected) * 100
+	}
+	return fmt.Sprintf("[%v] received %d/%d, lost %d (%.1f%%), duplicates %d, reordered %d",
+		strings.TrimPrefix(s.Label, SequencePrefix), s.Received, s.Expected, lost, loss, s.Duplicates, s.Reordered)
+}
+
+// Sequence sends numbered messages ("seq/count" padded to 1 KiB)
+// into the channel and checks the ones from the other side
+// for loss, duplication and reordering.
+type Sequence struct {
+	mu    sync.Mutex
+	stats SequenceStats
+	seen  map[int]bool
+	max   int
+	once  sync.Once
+	fn    func(SequenceStats)
+}
+
+// NewSequence runs the test when the channel opens,
+// fn gets the stats when the other side is done or at the end.
+func NewSequence(dc *DataChannel, done <-chan struct{}, fn func(SequenceStats)) *Sequence {
+	s := Sequence{
+		stats: SequenceStats{Label: dc.Label(), Expected: sequenceCount},
+		seen:  map[int]bool{},
+		max:   -1,
+		fn:    fn,
+	}
+	dc.OnMessage(s.receive)
+	dc.OnOpen(func() {
+		pad := strings.Repeat(" ", sequenceSize)
+		ticker := time.NewTicker(sequenceInterval)
+		defer ticker.Stop()
+		for i := 0; i < sequenceCount; i++ {
+			m := fmt.Sprintf("%d/%d ", i, sequenceCount)
+			if err := dc.SendText(m + pad[len(m):]); err != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+		select {
+		case <-done:
+		case <-time.After(sequenceWait):
+			s.Report()
+		}
+	})
+	return &s
+}
+
+func (s *Sequence) receive(data []byte) {
+	v, _, _ := strings.Cut(string(data), " ")
+	seq, count, ok := strings.Cut(v, "/")
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(seq)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if total, err := strconv.Atoi(count); err == nil {
+		s.stats.Expected = total
+	}
+	if s.seen[n] {
+		s.stats.Duplicates++
+		return
+	}
+	s.seen[n] = true
+	s.stats.Received++
+	if n < s.max {
+		s.stats.Reordered++
+	}
+	s.max = max(s.max, n)
+}
+
+// Report sends the stats into fn once.
+func (s *Sequence) Report() {
+	s.once.Do(func() {
+		s.mu.Lock()
+		st := s.stats
+		s.mu.Unlock()
+		s.fn(st)
+	})
+}
@@ -0,0 +1,57 @@
+package webrtc
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// echoKeyFrameInterval is how often the browser is asked for a video key frame,
+// so the echoed video starts soon after the renegotiation.
+const echoKeyFrameInterval = 2 * time.Second
+
+// Echo sends every incoming audio/video track back on a new local track.
+// The new tracks need a renegotiation, see OnRenegotiation.
+func (p *Peer) Echo(log func(format string, v ...any)) {
+	p.onTrack(func(remote *webrtc.TrackRemote) (func(pkt *rtp.Packet), func()) {
+		codec := remote.Codec()
+		local, err := webrtc.NewTrackLocalStaticRTP(codec.RTPCodecCapability, "echo-"+remote.ID(), "echo-"+remote.StreamID())
+		if err != nil {
+			log("echo %v fail: %v", remote.Kind(), err)
+			return nil, nil
+		}
+		sender, err := p.conn.AddTrack(local)
+		if err != nil {
+			log("echo %v fail: %v", remote.Kind(), err)
+			return nil, nil
+		}
+		log("echo %v track %v (%v, ssrc %v)", remote.Kind(), remote.ID(), codec.MimeType, remote.SSRC())
+
+		go drainRTCP(sender)
+		if remote.Kind() == webrtc.RTPCodecTypeVideo {
+			go func() {
+				ticker := time.NewTicker(echoKeyFrameInterval)
+				defer ticker.Stop()
+				for range ticker.C {
+					pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remote.SSRC())}}
+					if err := p.conn.WriteRTCP(pli); err != nil {
+						return
+					}
+				}
+			}()
+		}
+
+		var packets, bytes int
+		write := func(pkt *rtp.Packet) {
+			packets++
+			bytes += len(pkt.Payload)
+			_ = local.WriteRTP(pkt)
+		}
+		end := func() {
+			log("echo %v track %v is over, %v packets, %v KiB", remote.Kind(), remote.ID(), packets, bytes/1024)
+		}
+		return write, end
+	})
+}
@@ -0,0 +1,135 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+// filter decides which interfaces and IPs are used for candidate gathering
+// and reports each suppressed one once.
+type filter struct {
+	interfaces        []string
+	excludeInterfaces []string
+	ips               []*net.IPNet
+	excludeIPs        []*net.IPNet
+	loopback          bool
+
+	log  logging.LeveledLogger
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newFilter(conf Config, log logging.LeveledLogger) (*filter, error) {
+	f := filter{
+		interfaces:        conf.Interfaces,
+		excludeInterfaces: conf.ExcludeInterfaces,
+		loopback:          !conf.DisableLoopback,
+		log:               log,
+		seen:              map[string]struct{}{},
+	}
+	for _, p := range append(conf.Interfaces, conf.ExcludeInterfaces...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad interface pattern [%v], %w", p, err)
+		}
+	}
+	var err error
+	if f.ips, err = parseNets(conf.IPs); err != nil {
+		return nil, err
+	}
+	if f.excludeIPs, err = parseNets(conf.ExcludeIPs); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// parseNets reads a list of CIDRs or single IPs.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("bad IP [%v]", v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			v = fmt.Sprintf("%v/%d", ip, bits)
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad IP network [%v], %w", v, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (f *filter) apply(se *webrtc.SettingEngine) {
+	// loopback is dropped in the IP filter, so it gets reported
+	se.SetIncludeLoopbackCandidate(true)
+	se.SetInterfaceFilter(f.iface)
+	se.SetIPFilter(f.ip)
+}
+
+func (f *filter) iface(name string) bool {
+	if len(f.interfaces) > 0 && !match(f.interfaces, name) {
+		f.suppress("interface "+name, "not in the allow list")
+		return false
+	}
+	if match(f.excludeInterfaces, name) {
+		f.suppress("interface "+name, "in the deny list")
+		return false
+	}
+	return true
+}
+
+func (f *filter) ip(ip net.IP) bool {
+	switch {
+	case !f.loopback && ip.IsLoopback():
+		f.suppress("IP "+ip.String(), "loopback")
+		return false
+	case len(f.ips) > 0 && !contains(f.ips, ip):
+		f.suppress("IP "+ip.String(), "not in the allow list")
+		return false
+	case contains(f.excludeIPs, ip):
+		f.suppress("IP "+ip.String(), "in the deny list")
+		return false
+	}
+	return true
+}
+
+func (f *filter) suppress(what, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.seen[what]; ok {
+		return
+	}
+	f.seen[what] = struct{}{}
+	f.log.Infof("Suppressed candidates of %s: %s", what, reason)
+}
+
+func match(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
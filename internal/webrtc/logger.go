@@ -1,8 +1,10 @@
 package webrtc
 
 import (
-	"fmt"
+	"log"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/pion/logging"
 )
@@ -64,10 +66,62 @@ func NewLoggerFactory(lvl string, fn LogFn) CustomLoggerFactory {
 }
 
 func (c CustomLoggerFactory) NewLogger(subsystem string) logging.LeveledLogger {
-	fmt.Printf("Creating logger for %s \n", subsystem)
+	log.Printf("Creating logger for %s", subsystem)
 	return customLogger{
 		subsystem: subsystem,
 		level:     c.Level,
 		log:       c.Log,
 	}
 }
+
+// The log lines of the SCTP retransmissions, the counts depend on
+// their wording in pion/sctp, which TestRetransmitsLogLines checks.
+const (
+	sctpFastRetransmit = "[%s] fast-retransmit"
+	sctpT3Timeout      = "[%s] T3-rtx timed out"
+)
+
+// retransmits counts the SCTP retransmissions, which Pion
+// does not expose, by their trace and debug log lines.
+type retransmits struct {
+	fast atomic.Uint64
+	t3   atomic.Uint64
+}
+
+// tap makes the SCTP loggers count the retransmissions
+// before the log level filter.
+func (r *retransmits) tap(f logging.LoggerFactory) logging.LoggerFactory {
+	return retransmitsFactory{f, r}
+}
+
+type retransmitsFactory struct {
+	logging.LoggerFactory
+	r *retransmits
+}
+
+func (f retransmitsFactory) NewLogger(scope string) logging.LeveledLogger {
+	l := f.LoggerFactory.NewLogger(scope)
+	if scope != "sctp" {
+		return l
+	}
+	return retransmitsLogger{l, f.r}
+}
+
+type retransmitsLogger struct {
+	logging.LeveledLogger
+	r *retransmits
+}
+
+func (l retransmitsLogger) Tracef(f string, args ...any) {
+	if strings.HasPrefix(f, sctpFastRetransmit) {
+		l.r.fast.Add(1)
+	}
+	l.LeveledLogger.Tracef(f, args...)
+}
+
+func (l retransmitsLogger) Debugf(f string, args ...any) {
+	if strings.HasPrefix(f, sctpT3Timeout) {
+		l.r.t3.Add(1)
+	}
+	l.LeveledLogger.Debugf(f, args...)
+}
@@ -0,0 +1,59 @@
+package webrtc
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRetransmitsLogLines checks that the pion/sctp version of go.mod
+// still logs the retransmissions the way retransmitsLogger counts them.
+func TestRetransmitsLogLines(t *testing.T) {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/pion/sctp").Output()
+	if err != nil {
+		t.Fatalf("no pion/sctp sources: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(strings.TrimSpace(string(out)), "*.go"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no pion/sctp sources: %v", err)
+	}
+	var src strings.Builder
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.Write(b)
+	}
+
+	var r retransmits
+	l := r.tap(NewLoggerFactory("0", func(string, string, ...any) string { return "" })).NewLogger("sctp")
+	tests := []struct {
+		method string
+		prefix string
+		log    func(f string, args ...any)
+		count  *atomic.Uint64
+	}{
+		{"Tracef", sctpFastRetransmit, l.Tracef, &r.fast},
+		{"Debugf", sctpT3Timeout, l.Debugf, &r.t3},
+	}
+	for _, test := range tests {
+		call := regexp.MustCompile(`\.` + test.method + `\("(` + regexp.QuoteMeta(test.prefix) + `[^"]*)"`)
+		m := call.FindStringSubmatch(src.String())
+		if m == nil {
+			t.Errorf("pion/sctp has no %v(%q...) line, its retransmissions are not counted", test.method, test.prefix)
+			continue
+		}
+		test.log(m[1], "sctp", 1, 2, 3, 4)
+		if test.count.Load() != 1 {
+			t.Errorf("%q is not counted", m[1])
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package webrtc
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// MessageSizeLabel starts the labels of the message size test channels,
+	// which end with the direction (up or down) from the other side view.
+	MessageSizeLabel = "message size"
+	// messageSizeMin and messageSizeMax are the first and the last sizes to try.
+	messageSizeMin = 1024
+	messageSizeMax = 16 * 1024 * 1024
+	// messageSizeWait is the time to wait for the other side to get a message,
+	// or for the next message on the receiving side.
+	messageSizeWait = 5 * time.Second
+	// messageSizeAttr is the SDP attribute with the largest message a side takes (RFC 8841).
+	messageSizeAttr = "a=max-message-size:"
+)
+
+// MessageSizeResult is one direction of the max message size test.
+type MessageSizeResult struct {
+	// Send is true for the messages sent by this peer
+	Send bool
+	// Local and Remote are the max-message-size values of the SDPs, 0 if there is none
+	Local  int
+	Remote int
+	// Passed is the largest message that has got through,
+	// Failed is the first one that has not and why
+	Passed int
+	Failed int
+	Reason string
+}
+
+func (r MessageSizeResult) String() string {
+	sdp := fmt.Sprintf("(SDP max-message-size local: %v, remote: %v)", sdpSize(r.Local), sdpSize(r.Remote))
+	if !r.Send {
+		return fmt.Sprintf("received up to %v bytes %v", r.Passed, sdp)
+	}
+	if r.Failed == 0 {
+		return fmt.Sprintf("sent up to %v bytes, all went through %v", r.Passed, sdp)
+	}
+	return fmt.Sprintf("sent up to %v bytes, %v failed: %v %v", r.Passed, r.Failed, r.Reason, sdp)
+}
+
+func sdpSize(v int) string {
+	if v == 0 {
+		return "none"
+	}
+	return strconv.Itoa(v)
+}
+
+// MessageSize finds the largest message that goes through a new data channel.
+// The sender sends binary messages of growing size and the receiver
+// answers each with the "ok <size>" text message, the sender closes the channel at the end.
+// The sizes are powers of two and the remote max-message-size with one byte more.
+func (p *Peer) MessageSize(send bool, fn func(MessageSizeResult)) error {
+	// the label has the direction from the other side view
+	label := MessageSizeLabel + " up"
+	if send {
+		label = MessageSizeLabel + " down"
+	}
+	ch, err := p.conn.CreateDataChannel(label, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ch.Close() }()
+
+	r := MessageSizeResult{Send: send}
+	if sdp := p.conn.LocalDescription(); sdp != nil {
+		r.Local = maxMessageSize(sdp.SDP)
+	}
+	if sdp := p.conn.RemoteDescription(); sdp != nil {
+		r.Remote = maxMessageSize(sdp.SDP)
+	}
+
+	acks, got := make(chan int, 1), make(chan struct{}, 1)
+	var received atomic.Int64
+	opened, closed := make(chan struct{}), make(chan struct{})
+	ch.OnOpen(func() { close(opened) })
+	ch.OnClose(func() { close(closed) })
+	ch.OnMessage(func(m webrtc.DataChannelMessage) {
+		if !m.IsString {
+			received.Store(max(received.Load(), int64(len(m.Data))))
+			_ = ch.SendText(fmt.Sprintf("ok %d", len(m.Data)))
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+			return
+		}
+		if v, ok := strings.CutPrefix(string(m.Data), "ok "); ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				select {
+				case acks <- n:
+				default:
+				}
+			}
+		}
+	})
+	select {
+	case <-opened:
+	case <-time.After(messageSizeWait):
+		return fmt.Errorf("the %v channel is not open", label)
+	}
+
+	if !send {
+		if !waitClose(got, closed) {
+			return fmt.Errorf("no message in %v on the %v channel", messageSizeWait, label)
+		}
+		r.Passed = int(received.Load())
+		fn(r)
+		return nil
+	}
+
+	for _, size := range messageSizes(r.Remote) {
+		if err := ch.Send(make([]byte, size)); err != nil {
+			r.Failed, r.Reason = size, err.Error()
+			break
+		}
+		if reason := waitAck(acks, closed, size); reason != "" {
+			r.Failed, r.Reason = size, reason
+			break
+		}
+		r.Passed = size
+	}
+	fn(r)
+	return nil
+}
+
+func waitAck(acks <-chan int, closed <-chan struct{}, size int) string {
+	timeout := time.After(messageSizeWait)
+	for {
+		select {
+		case n := <-acks:
+			if n == size {
+				return ""
+			}
+		case <-closed:
+			return "the channel has been closed"
+		case <-timeout:
+			return fmt.Sprintf("no answer in %v", messageSizeWait)
+		}
+	}
+}
+
+// waitClose waits for the sender to close the channel at the end,
+// each received message restarts the wait.
+func waitClose(got, closed <-chan struct{}) bool {
+	for {
+		select {
+		case <-closed:
+			return true
+		case <-got:
+		case <-time.After(messageSizeWait):
+			return false
+		}
+	}
+}
+
+// messageSizes are the message sizes to try in order.
+func messageSizes(remote int) []int {
+	var sizes []int
+	for s := messageSizeMin; s <= messageSizeMax; s *= 2 {
+		sizes = append(sizes, s)
+	}
+	if remote > 0 && remote < messageSizeMax {
+		sizes = append(sizes, remote, remote+1)
+	}
+	slices.Sort(sizes)
+	return slices.Compact(sizes)
+}
+
+// maxMessageSize reads the max-message-size attribute of the SDP.
+func maxMessageSize(sdp string) int {
+	for _, line := range strings.Split(sdp, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), messageSizeAttr); ok {
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+// Mux is a server-wide pair of UDP and TCP muxes shared by all the sessions,
+// so they can use the same ports at the same time.
+type Mux struct {
+	UDPPort int
+	TCPPort int
+
+	udp *ice.MultiUDPMuxDefault
+	tcp ice.TCPMux
+}
+
+// NewMux listens on the UDP and TCP ports if they are not zero.
+func NewMux(udpPort, tcpPort int, logger logging.LoggerFactory) (*Mux, error) {
+	m := Mux{UDPPort: udpPort, TCPPort: tcpPort}
+	if udpPort > 0 {
+		udp, err := newUDPMux(udpPort, logger.NewLogger("udp"), ice.UDPMuxFromPortWithLoopback())
+		if err != nil {
+			return nil, err
+		}
+		m.udp = udp
+	}
+	if tcpPort > 0 {
+		tcp, err := newTCPMux(tcpPort, logger.NewLogger("tcp"))
+		if err != nil {
+			_ = m.Close()
+			return nil, err
+		}
+		m.tcp = tcp
+	}
+	return &m, nil
+}
+
+func (m *Mux) String() string {
+	var addrs []net.Addr
+	if m.udp != nil {
+		addrs = m.udp.GetListenAddresses()
+	}
+	return fmt.Sprintf("udp %v, tcp :%v", addrs, m.TCPPort)
+}
+
+// Close stops the muxes.
+func (m *Mux) Close() error {
+	var err error
+	if m.udp != nil {
+		err = m.udp.Close()
+	}
+	if m.tcp != nil {
+		err = errors.Join(err, m.tcp.Close())
+	}
+	return err
+}
+
+// newUDPMux opens a socket on the port for each local IPv4 and IPv6 address.
+func newUDPMux(port int, log logging.LeveledLogger, opts ...ice.UDPMuxFromPortOption) (*ice.MultiUDPMuxDefault, error) {
+	opts = append([]ice.UDPMuxFromPortOption{
+		ice.UDPMuxFromPortWithNetworks(ice.NetworkTypeUDP4, ice.NetworkTypeUDP6),
+		ice.UDPMuxFromPortWithLogger(log),
+	}, opts...)
+	return ice.NewMultiUDPMuxFromPort(port, opts...)
+}
+
+// newTCPMux listens on the port of all the IPv4 and IPv6 addresses (dual-stack),
+// as the passive candidates of both families are gathered.
+func newTCPMux(port int, log logging.LeveledLogger) (ice.TCPMux, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	return webrtc.NewICETCPMux(log, l, 8), nil
+}
+
+// udpMuxView is a session view of the shared UDP mux
+// which hides the addresses filtered out by the session.
+type udpMuxView struct {
+	ice.UDPMux
+	filter   *filter
+	networks []ice.NetworkType
+}
+
+func (v udpMuxView) GetListenAddresses() []net.Addr {
+	var addrs []net.Addr
+	for _, a := range v.UDPMux.GetListenAddresses() {
+		addr, ok := a.(*net.UDPAddr)
+		if !ok || !v.network(addr.IP) || !v.filter.ip(addr.IP) {
+			continue
+		}
+		if name := interfaceOf(addr.IP); name != "" && !v.filter.iface(name) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func (v udpMuxView) network(ip net.IP) bool {
+	for _, n := range v.networks {
+		if (n == ice.NetworkTypeUDP4) == (ip.To4() != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceOf returns the name of the network interface with the IP.
+func interfaceOf(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
@@ -1,9 +1,11 @@
 package webrtc
 
 import (
+	"errors"
 	"fmt"
-	"net"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/pion/ice/v4"
 	"github.com/pion/interceptor"
@@ -15,27 +17,122 @@ type (
 	Connection struct {
 		*webrtc.PeerConnection
 
-		api      *webrtc.API
-		config   *webrtc.Configuration
-		listener *net.UDPConn
+		api    *webrtc.API
+		config *webrtc.Configuration
+		// udpMux and tcpMux are session-owned muxes
+		udpMux *ice.MultiUDPMuxDefault
+		tcpMux ice.TCPMux
+		rtx    *retransmits
+		// conf is the effective configuration
+		conf Config
 	}
+	// Config is a peer connection configuration,
+	// where the JSON names match the session options.
 	Config struct {
-		DisableDefaultInterceptors bool
-		DisableMDNS                bool
-		DtlsRole                   int
-		IceLite                    bool
-		IcePortMin                 int
-		IcePortMax                 int
-		IceServers                 []webrtc.ICEServer
-		Logger                     logging.LoggerFactory
-		Nat1to1                    string
-		SinglePort                 int
+		DisableDefaultInterceptors bool `json:"disable_interceptors"`
+		DisableMDNS                bool `json:"disable_mdns"`
+		// DtlsRole is the answering DTLS role: auto, client or server.
+		DtlsRole   string                `json:"dtls_role"`
+		IceLite    bool                  `json:"ice_lite"`
+		IcePortMin int                   `json:"ice_port_min"`
+		IcePortMax int                   `json:"ice_port_max"`
+		IceServers []webrtc.ICEServer    `json:"ice_servers"`
+		Logger     logging.LoggerFactory `json:"-"`
+		Nat1to1    string                `json:"nat1to1"`
+		RelayOnly  bool                  `json:"ice_relay_only"`
+		SinglePort int                   `json:"port"`
+		// TCPPort enables passive ICE-TCP candidates on the port.
+		TCPPort int `json:"tcp_port"`
+		// TCPOnly skips gathering of UDP candidates.
+		TCPOnly bool `json:"ice_tcp_only"`
+		// NetworkTypes limits gathering to udp4, udp6, tcp4 or tcp6.
+		NetworkTypes []string `json:"network_types"`
+		// Interfaces and ExcludeInterfaces are name patterns (i.e. eth*)
+		// of the network interfaces allowed or denied for gathering.
+		Interfaces        []string `json:"interfaces"`
+		ExcludeInterfaces []string `json:"exclude_interfaces"`
+		// IPs and ExcludeIPs are IPs or CIDRs allowed or denied for gathering.
+		IPs             []string `json:"ips"`
+		ExcludeIPs      []string `json:"exclude_ips"`
+		DisableLoopback bool     `json:"disable_loopback"`
+		// SCTPMaxMessageSize is the largest incoming message (the SDP max-message-size),
+		// SCTPReceiveBuffer is the receive buffer size and SCTPRTOMax is
+		// the max retransmission timeout in ms, zero values keep the defaults.
+		SCTPMaxMessageSize int `json:"sctp_max_message_size"`
+		SCTPReceiveBuffer  int `json:"sctp_receive_buffer"`
+		SCTPRTOMax         int `json:"sctp_rto_max"`
+		// Mux is an optional server-wide mux used by default
+		// or when the session asks for the same ports.
+		Mux *Mux `json:"-"`
 	}
 )
 
-var settings webrtc.SettingEngine
+var dtlsRoles = map[string]webrtc.DTLSRole{
+	"":       webrtc.DTLSRoleAuto,
+	"auto":   webrtc.DTLSRoleAuto,
+	"client": webrtc.DTLSRoleClient,
+	"server": webrtc.DTLSRoleServer,
+}
+
+// namedValue is a config value with its name for the errors.
+type namedValue struct {
+	name string
+	v    int
+}
+
+// Validate checks the values and their combinations,
+// always in the same order, so the same config gets the same error.
+func (c *Config) Validate() error {
+	for _, port := range []namedValue{
+		{"port", c.SinglePort}, {"tcp port", c.TCPPort}, {"min ICE port", c.IcePortMin}, {"max ICE port", c.IcePortMax},
+	} {
+		if port.v < 0 || port.v > 65535 {
+			return fmt.Errorf("bad %v [%v]", port.name, port.v)
+		}
+	}
+	for _, size := range []namedValue{
+		{"SCTP max message size", c.SCTPMaxMessageSize}, {"SCTP receive buffer", c.SCTPReceiveBuffer},
+		{"SCTP max RTO", c.SCTPRTOMax},
+	} {
+		if size.v < 0 || int64(size.v) > math.MaxUint32 {
+			return fmt.Errorf("bad %v [%v]", size.name, size.v)
+		}
+	}
+	if (c.IcePortMin > 0) != (c.IcePortMax > 0) {
+		return errors.New("the ICE port range needs both min and max ports")
+	}
+	if c.IcePortMin > c.IcePortMax {
+		return fmt.Errorf("bad ICE port range [%v-%v]", c.IcePortMin, c.IcePortMax)
+	}
+	if c.IcePortMin > 0 && c.SinglePort > 0 {
+		return errors.New("a single port and an ICE port range can't be used together")
+	}
+	if _, ok := dtlsRoles[c.DtlsRole]; !ok {
+		return fmt.Errorf("bad DTLS role [%v], should be auto, client or server", c.DtlsRole)
+	}
+	if c.IceLite && c.RelayOnly {
+		return errors.New("ICE-lite gathers host candidates only, so it can't be relay only")
+	}
+	if c.Nat1to1 != "" {
+		if _, _, err := parseNatCandidate(c.Nat1to1); err != nil {
+			return err
+		}
+	}
+	if _, err := networkTypes(c.NetworkTypes, c.TCPOnly, c.tcpMux()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// tcpMux tells whether passive ICE-TCP candidates are on,
+// with the session port or the shared one.
+func (c *Config) tcpMux() bool { return c.TCPPort > 0 || c.Mux != nil && c.Mux.tcp != nil }
 
 func DefaultConnection(conf Config) (*Connection, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
 	m := &webrtc.MediaEngine{}
 	if err := m.RegisterDefaultCodecs(); err != nil {
 		return nil, err
@@ -52,70 +149,169 @@ func DefaultConnection(conf Config) (*Connection, error) {
 		log.Debugf("Default interceptors have been disabled")
 	}
 
-	var udpConn *net.UDPConn
+	var udpMux *ice.MultiUDPMuxDefault
 
-	se := webrtc.SettingEngine{}
-
-	se.SetIncludeLoopbackCandidate(true)
+	rtx := &retransmits{}
+	se := webrtc.SettingEngine{LoggerFactory: rtx.tap(conf.Logger)}
 
 	if conf.DisableMDNS {
 		se.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
 	}
 
-	if conf.Logger != nil {
-		se = webrtc.SettingEngine{LoggerFactory: conf.Logger}
+	filter, err := newFilter(conf, conf.Logger.NewLogger("filter"))
+	if err != nil {
+		return nil, err
+	}
+	filter.apply(&se)
+
+	// the types are always set, so the reported ones are the gathered ones
+	networks, err := networkTypes(conf.NetworkTypes, conf.TCPOnly, conf.tcpMux())
+	if err != nil {
+		return nil, err
 	}
-	if conf.DtlsRole > 0 {
-		log.Debugf("A custom DTLS role [%v]", conf.DtlsRole)
-		if err := se.SetAnsweringDTLSRole(webrtc.DTLSRole(conf.DtlsRole)); err != nil {
+	log.Debugf("Using %v candidates", networks)
+	se.SetNetworkTypes(networks)
+	conf.NetworkTypes = nil
+	for _, n := range networks {
+		conf.NetworkTypes = append(conf.NetworkTypes, n.String())
+	}
+	if role := dtlsRoles[conf.DtlsRole]; role != webrtc.DTLSRoleAuto {
+		log.Debugf("A custom DTLS role [%v]", role)
+		if err := se.SetAnsweringDTLSRole(role); err != nil {
 			return nil, err
 		}
 	}
+	conf.DtlsRole = dtlsRoles[conf.DtlsRole].String()
 	if conf.IceLite {
+		log.Debugf("Using ICE-lite")
 		se.SetLite(conf.IceLite)
 	}
 	if conf.IcePortMin > 0 && conf.IcePortMax > 0 {
+		log.Debugf("Using ICE ports %v-%v", conf.IcePortMin, conf.IcePortMax)
 		if err := se.SetEphemeralUDPPortRange(uint16(conf.IcePortMin), uint16(conf.IcePortMax)); err != nil {
 			return nil, err
 		}
-	} else {
-		if conf.SinglePort > 0 {
-			udpListener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{0, 0, 0, 0}, Port: conf.SinglePort})
+	} else if udp := udpNetworks(networks); len(udp) > 0 {
+		var shared ice.UDPMux
+		switch {
+		case conf.Mux != nil && conf.Mux.udp != nil && (conf.SinglePort == 0 || conf.SinglePort == conf.Mux.UDPPort):
+			shared, conf.SinglePort = conf.Mux.udp, conf.Mux.UDPPort
+			log.Debugf("Using the shared UDP port %v", conf.SinglePort)
+		case conf.SinglePort > 0:
+			mux, err := newUDPMux(conf.SinglePort, conf.Logger.NewLogger("udp"), ice.UDPMuxFromPortWithLoopback())
 			if err != nil {
 				return nil, err
 			}
-			udpConn = udpListener
-			log.Debugf("Listening for WebRTC traffic at %s", udpListener.LocalAddr())
-			se.SetICEUDPMux(webrtc.NewICEUDPMux(nil, udpListener))
+			udpMux, shared = mux, mux
+			log.Debugf("Listening for WebRTC traffic at %s", mux.GetListenAddresses())
+		}
+		if shared != nil {
+			se.SetICEUDPMux(udpMuxView{UDPMux: shared, filter: filter, networks: udp})
 		}
 	}
-	if conf.Nat1to1 != "" {
-		if ip, ct, err := parseNatCandidate(conf.Nat1to1); err == nil {
-			se.SetNAT1To1IPs(ip, ct)
-			log.Debugf("Using 1:1 NAT %s", conf.Nat1to1)
-		} else {
-			log.Errorf("NAT map error: %v", err)
+	var tcpMux ice.TCPMux
+	switch {
+	case conf.Mux != nil && conf.Mux.tcp != nil && (conf.TCPPort == 0 || conf.TCPPort == conf.Mux.TCPPort):
+		conf.TCPPort = conf.Mux.TCPPort
+		log.Debugf("Using the shared ICE-TCP port %v", conf.TCPPort)
+		se.SetICETCPMux(conf.Mux.tcp)
+	case conf.TCPPort > 0:
+		mux, err := newTCPMux(conf.TCPPort, conf.Logger.NewLogger("tcp"))
+		if err != nil {
+			if udpMux != nil {
+				_ = udpMux.Close()
+			}
+			return nil, err
 		}
+		log.Debugf("Listening for ICE-TCP traffic at :%v", conf.TCPPort)
+		tcpMux = mux
+		se.SetICETCPMux(tcpMux)
+	}
+	if conf.SCTPMaxMessageSize > 0 {
+		log.Debugf("Using SCTP max message size %v", conf.SCTPMaxMessageSize)
+		se.SetSCTPMaxMessageSize(uint32(conf.SCTPMaxMessageSize))
+	}
+	if conf.SCTPReceiveBuffer > 0 {
+		log.Debugf("Using SCTP receive buffer %v", conf.SCTPReceiveBuffer)
+		se.SetSCTPMaxReceiveBufferSize(uint32(conf.SCTPReceiveBuffer))
+	}
+	if conf.SCTPRTOMax > 0 {
+		log.Debugf("Using SCTP max RTO %vms", conf.SCTPRTOMax)
+		se.SetSCTPRTOMax(time.Duration(conf.SCTPRTOMax) * time.Millisecond)
+	}
+	if conf.Nat1to1 != "" {
+		ip, ct, _ := parseNatCandidate(conf.Nat1to1)
+		se.SetNAT1To1IPs(ip, ct)
+		log.Debugf("Using 1:1 NAT %s", conf.Nat1to1)
 	}
-	settings = se
 
 	peerConf := webrtc.Configuration{ICEServers: []webrtc.ICEServer{}}
 	if len(conf.IceServers) > 0 {
 		peerConf.ICEServers = conf.IceServers
 	}
+	if conf.RelayOnly {
+		log.Debugf("Using relay candidates only")
+		peerConf.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
 
 	conn := Connection{
 		api: webrtc.NewAPI(
 			webrtc.WithMediaEngine(m),
 			webrtc.WithInterceptorRegistry(i),
-			webrtc.WithSettingEngine(settings),
+			webrtc.WithSettingEngine(se),
 		),
-		config:   &peerConf,
-		listener: udpConn,
+		config: &peerConf,
+		udpMux: udpMux,
+		tcpMux: tcpMux,
+		rtx:    rtx,
+		conf:   conf,
 	}
 	return &conn, nil
 }
 
+// networkTypes converts names into network types,
+// where UDP is used by default and TCP with a TCP mux or TCP only.
+func networkTypes(names []string, tcpOnly, tcp bool) ([]webrtc.NetworkType, error) {
+	all := []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6}
+	if tcp || tcpOnly {
+		all = append(all, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6)
+	}
+	if len(names) > 0 {
+		all = nil
+		for _, name := range names {
+			t, err := webrtc.NewNetworkType(name)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, t)
+		}
+	}
+	var types []webrtc.NetworkType
+	for _, t := range all {
+		if tcpOnly && t != webrtc.NetworkTypeTCP4 && t != webrtc.NetworkTypeTCP6 {
+			continue
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no network types left to gather candidates on")
+	}
+	return types, nil
+}
+
+func udpNetworks(types []webrtc.NetworkType) []ice.NetworkType {
+	var udp []ice.NetworkType
+	for _, t := range types {
+		switch t {
+		case webrtc.NetworkTypeUDP4:
+			udp = append(udp, ice.NetworkTypeUDP4)
+		case webrtc.NetworkTypeUDP6:
+			udp = append(udp, ice.NetworkTypeUDP6)
+		}
+	}
+	return udp
+}
+
 func parseNatCandidate(v string) (ips []string, candidateType webrtc.ICECandidateType, err error) {
 	parts := strings.Split(v, "/")
 	if len(parts) < 2 {
@@ -137,11 +333,14 @@ func (p *Connection) Connect() error {
 
 func (p *Connection) Close() error {
 	var err error
-	if p.listener != nil {
-		err = p.listener.Close()
-	}
 	if p.PeerConnection != nil {
 		err = p.PeerConnection.Close()
 	}
+	if p.udpMux != nil {
+		err = errors.Join(err, p.udpMux.Close())
+	}
+	if p.tcpMux != nil {
+		err = errors.Join(err, p.tcpMux.Close())
+	}
 	return err
 }
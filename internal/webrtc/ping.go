@@ -0,0 +1,135 @@
+package webrtc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pingLossTimeout is the time after which an unanswered ping is lost.
+const pingLossTimeout = 3 * time.Second
+
+// Ping measures the application-level round-trip time over a data channel.
+// Both sides send "ping <seq> <timestamp>" text messages
+// and answer them with "pong <seq> <timestamp>" with the same numbers.
+type Ping struct {
+	dc    *DataChannel
+	start time.Time
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]time.Time
+	stats   PingStats
+	last    time.Duration
+}
+
+// PingStats is the round-trip time summary of a session.
+type PingStats struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Lost     int           `json:"lost"`
+	Min      time.Duration `json:"min"`
+	Max      time.Duration `json:"max"`
+	Avg      time.Duration `json:"avg"`
+	// Jitter is the mean deviation of consecutive RTTs (RFC 3550)
+	Jitter time.Duration `json:"jitter"`
+
+	sum time.Duration
+}
+
+func (s PingStats) String() string {
+	if s.Received == 0 {
+		return fmt.Sprintf("%d pings, no pongs", s.Sent)
+	}
+	loss := float64(s.Lost) / float64(s.Received+s.Lost) * 100
+	r := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	return fmt.Sprintf("%d pings, rtt min/avg/max %v/%v/%v, jitter %v, loss %.1f%%",
+		s.Sent, r(s.Min), r(s.Avg), r(s.Max), r(s.Jitter), loss)
+}
+
+// NewPing answers the pings of the other side and sends
+// its own pings with the interval when the channel opens until done.
+func NewPing(dc *DataChannel, interval time.Duration, done <-chan struct{}) *Ping {
+	p := Ping{dc: dc, start: time.Now(), pending: map[uint64]time.Time{}}
+	dc.OnMessage(p.receive)
+	dc.OnOpen(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.ping()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+	return &p
+}
+
+func (p *Ping) ping() {
+	p.mu.Lock()
+	p.seq++
+	seq, now := p.seq, time.Now()
+	p.pending[seq] = now
+	p.stats.Sent++
+	p.mu.Unlock()
+	_ = p.dc.SendText(fmt.Sprintf("ping %d %d", seq, now.Sub(p.start).Milliseconds()))
+}
+
+func (p *Ping) receive(data []byte) {
+	kind, rest, _ := strings.Cut(string(data), " ")
+	switch kind {
+	case "ping":
+		_ = p.dc.SendText("pong " + rest)
+	case "pong":
+		v, _, _ := strings.Cut(rest, " ")
+		seq, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return
+		}
+		p.pong(seq, time.Now())
+	}
+}
+
+func (p *Ping) pong(seq uint64, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sent, ok := p.pending[seq]
+	if !ok {
+		return
+	}
+	delete(p.pending, seq)
+	rtt := now.Sub(sent)
+
+	s := &p.stats
+	if s.Received == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+	if s.Received > 0 {
+		s.Jitter += ((rtt - p.last).Abs() - s.Jitter) / 16
+	}
+	p.last = rtt
+	s.Received++
+	s.sum += rtt
+	s.Avg = s.sum / time.Duration(s.Received)
+}
+
+// Stats returns the summary, the pings without answers
+// for some time are counted as lost.
+func (p *Ping) Stats() PingStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	for _, sent := range p.pending {
+		if time.Since(sent) > pingLossTimeout {
+			s.Lost++
+		}
+	}
+	return s
+}
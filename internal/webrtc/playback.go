@@ -0,0 +1,87 @@
+package webrtc
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	pmedia "github.com/pion/webrtc/v4/pkg/media"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/media"
+)
+
+// Play sends the named files of the directory in a loop until done,
+// each file on its own track paced by the file timestamps.
+// The tracks need a renegotiation after the first one, see OnRenegotiation.
+func (p *Peer) Play(dir string, names []string, done <-chan struct{}, log func(format string, v ...any)) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = root.Close() }()
+
+	var files []*media.File
+	closeAll := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	for i, name := range names {
+		file, err := media.OpenFile(root, name)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		files = append(files, file)
+
+		track, err := webrtc.NewTrackLocalStaticSample(
+			webrtc.RTPCodecCapability{MimeType: file.MimeType}, fmt.Sprintf("play-%d", i), "play")
+		if err == nil {
+			var sender *webrtc.RTPSender
+			if sender, err = p.conn.AddTrack(track); err == nil {
+				go drainRTCP(sender)
+			}
+		}
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		log("play %v (%v)", name, file.MimeType)
+		go play(name, file, track, done, log)
+	}
+	return nil
+}
+
+// play writes the frames of the file on time.
+func play(name string, file *media.File, track *webrtc.TrackLocalStaticSample, done <-chan struct{}, log func(format string, v ...any)) {
+	defer func() { _ = file.Close() }()
+	var frames, bytes int
+	err := func() error {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		next := time.Now()
+		for {
+			data, d, err := file.Next()
+			if err != nil {
+				return err
+			}
+			if err = track.WriteSample(pmedia.Sample{Data: data, Duration: d}); err != nil {
+				return err
+			}
+			frames++
+			bytes += len(data)
+
+			next = next.Add(d)
+			timer.Reset(time.Until(next))
+			select {
+			case <-done:
+				return nil
+			case <-timer.C:
+			}
+		}
+	}()
+	if err != nil {
+		log("play %v err: %v", name, err)
+	}
+	log("play %v is over, %v frames, %v KiB, %v loops", name, frames, bytes/1024, file.Loops)
+}
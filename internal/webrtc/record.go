@@ -0,0 +1,132 @@
+package webrtc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v4/pkg/media/rtpdump"
+)
+
+// Recording is a file with an incoming track.
+type Recording struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Codec string `json:"codec"`
+}
+
+// mediaWriter saves the RTP packets into a file.
+type mediaWriter interface {
+	WriteRTP(pkt *rtp.Packet) error
+	Close() error
+}
+
+// Record saves every incoming track into the directory as <prefix>-<n>-<kind> files:
+// VP8/VP9/AV1 into IVF, Opus into Ogg, and all of them into rtpdump
+// with the RTP packets as they have arrived.
+// Each new file is passed to saved.
+func (p *Peer) Record(dir, prefix string, saved func(Recording), log func(format string, v ...any)) {
+	// the tracks start in their own goroutines
+	var n atomic.Int32
+	p.onTrack(func(remote *webrtc.TrackRemote) (func(pkt *rtp.Packet), func()) {
+		codec := remote.Codec()
+		name := fmt.Sprintf("%v-%d-%v", prefix, n.Add(1), remote.Kind())
+
+		type file struct {
+			ext  string
+			open func(path string) (mediaWriter, error)
+		}
+		var files []file
+		for _, mime := range []string{webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeAV1} {
+			if strings.EqualFold(codec.MimeType, mime) {
+				files = append(files, file{".ivf", func(path string) (mediaWriter, error) {
+					// the standard timebase of the RTP clock, so the file plays anywhere
+					return ivfwriter.New(path, ivfwriter.WithCodec(mime),
+						ivfwriter.WithFrameRate(1, codec.ClockRate), ivfwriter.WithDirectPTS())
+				}})
+			}
+		}
+		if strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus) {
+			files = append(files, file{".ogg", func(path string) (mediaWriter, error) {
+				return oggwriter.New(path, codec.ClockRate, codec.Channels)
+			}})
+		}
+		files = append(files, file{".rtpdump", newRTPDump})
+
+		var writers []mediaWriter
+		for _, f := range files {
+			w, err := f.open(filepath.Join(dir, name+f.ext))
+			if err != nil {
+				log("record %v fail: %v", name+f.ext, err)
+				continue
+			}
+			writers = append(writers, w)
+			saved(Recording{Name: name + f.ext, Kind: remote.Kind().String(), Codec: codec.MimeType})
+		}
+		if len(writers) == 0 {
+			return nil, nil
+		}
+		log("record %v track %v (%v) into %v", remote.Kind(), remote.ID(), codec.MimeType, name)
+
+		var packets int
+		write := func(pkt *rtp.Packet) {
+			packets++
+			for i, w := range writers {
+				if w == nil {
+					continue
+				}
+				if err := w.WriteRTP(pkt); err != nil {
+					log("record %v err: %v", name, err)
+					_ = w.Close()
+					writers[i] = nil
+				}
+			}
+		}
+		end := func() {
+			for _, w := range writers {
+				if w != nil {
+					_ = w.Close()
+				}
+			}
+			log("record %v is over, %v packets", name, packets)
+		}
+		return write, end
+	})
+}
+
+// rtpDump writes the packets into an rtpdump file with their arrival time.
+type rtpDump struct {
+	f     *os.File
+	w     *rtpdump.Writer
+	start time.Time
+}
+
+func newRTPDump(path string) (mediaWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d := rtpDump{f: f, start: time.Now()}
+	if d.w, err = rtpdump.NewWriter(f, rtpdump.Header{Start: d.start}); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (d *rtpDump) WriteRTP(pkt *rtp.Packet) error {
+	raw, err := pkt.Marshal()
+	if err != nil {
+		return err
+	}
+	return d.w.WritePacket(rtpdump.Packet{Offset: time.Since(d.start), Payload: raw})
+}
+
+func (d *rtpDump) Close() error { return d.f.Close() }
@@ -0,0 +1,52 @@
+package webrtc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// EncodeSDP packs the session description into a short text to copy and paste,
+// which is base64 of the deflated (zlib) JSON, like the web page does.
+func EncodeSDP(sd webrtc.SessionDescription) (string, error) {
+	data, err := json.Marshal(sd)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeSDP unpacks the session description,
+// it ignores the whitespace which may appear when copying.
+func DecodeSDP(v string) (sd webrtc.SessionDescription, err error) {
+	v = strings.Join(strings.Fields(v), "")
+	data, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return sd, fmt.Errorf("bad SDP text, %w", err)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return sd, fmt.Errorf("bad SDP text, %w", err)
+	}
+	if data, err = io.ReadAll(r); err != nil {
+		return sd, fmt.Errorf("bad SDP text, %w", err)
+	}
+	if err = json.Unmarshal(data, &sd); err != nil {
+		return sd, fmt.Errorf("bad SDP, %w", err)
+	}
+	return sd, nil
+}
@@ -0,0 +1,198 @@
+package webrtc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type (
+	// Stats is a condensed view of the Pion getStats report.
+	Stats struct {
+		Selected  *PairStats      `json:"selected,omitempty"`
+		Pairs     []PairStats     `json:"pairs,omitempty"`
+		Transport TransportStats  `json:"transport"`
+		Channels  []ChannelStats  `json:"channels,omitempty"`
+		Inbound   []InboundStats  `json:"inbound,omitempty"`
+		Outbound  []OutboundStats `json:"outbound,omitempty"`
+		SCTP      *SCTPStats      `json:"sctp,omitempty"`
+		Time      time.Time       `json:"time"`
+	}
+	CandidateStats struct {
+		Type     string `json:"type"`
+		Protocol string `json:"protocol"`
+		Address  string `json:"address"`
+		Port     int32  `json:"port"`
+		Relay    string `json:"relay,omitempty"`
+		URL      string `json:"url,omitempty"`
+	}
+	PairStats struct {
+		ID              string         `json:"id"`
+		State           string         `json:"state"`
+		Nominated       bool           `json:"nominated"`
+		Local           CandidateStats `json:"local"`
+		Remote          CandidateStats `json:"remote"`
+		RTT             float64        `json:"rtt"`
+		BytesSent       uint64         `json:"bytesSent"`
+		BytesReceived   uint64         `json:"bytesReceived"`
+		PacketsSent     uint32         `json:"packetsSent"`
+		PacketsReceived uint32         `json:"packetsReceived"`
+		Requests        uint64         `json:"requests"`
+		Responses       uint64         `json:"responses"`
+	}
+	TransportStats struct {
+		BytesSent     uint64 `json:"bytesSent"`
+		BytesReceived uint64 `json:"bytesReceived"`
+	}
+	ChannelStats struct {
+		Label            string `json:"label"`
+		State            string `json:"state"`
+		MessagesSent     uint32 `json:"messagesSent"`
+		MessagesReceived uint32 `json:"messagesReceived"`
+		BytesSent        uint64 `json:"bytesSent"`
+		BytesReceived    uint64 `json:"bytesReceived"`
+	}
+	InboundStats struct {
+		SSRC            uint32  `json:"ssrc"`
+		Kind            string  `json:"kind"`
+		PacketsReceived uint32  `json:"packetsReceived"`
+		PacketsLost     int32   `json:"packetsLost"`
+		BytesReceived   uint64  `json:"bytesReceived"`
+		Jitter          float64 `json:"jitter"`
+		NACKs           uint32  `json:"nacks"`
+		PLIs            uint32  `json:"plis"`
+	}
+	OutboundStats struct {
+		SSRC        uint32 `json:"ssrc"`
+		Kind        string `json:"kind"`
+		PacketsSent uint32 `json:"packetsSent"`
+		BytesSent   uint64 `json:"bytesSent"`
+		NACKs       uint32 `json:"nacks"`
+		PLIs        uint32 `json:"plis"`
+	}
+	SCTPStats struct {
+		RTT              float64 `json:"rtt"`
+		CongestionWindow uint32  `json:"cwnd"`
+		ReceiverWindow   uint32  `json:"rwnd"`
+		MTU              uint32  `json:"mtu"`
+		UnackData        uint32  `json:"unackData"`
+		BytesSent        uint64  `json:"bytesSent"`
+		BytesReceived    uint64  `json:"bytesReceived"`
+	}
+)
+
+// Stats makes a snapshot of the current connection stats.
+func (p *Peer) Stats() Stats {
+	report := p.conn.GetStats()
+
+	candidates := map[string]CandidateStats{}
+	for _, s := range report {
+		if c, ok := s.(webrtc.ICECandidateStats); ok {
+			candidates[c.ID] = CandidateStats{
+				Type:     c.CandidateType.String(),
+				Protocol: c.Protocol,
+				Address:  c.IP,
+				Port:     c.Port,
+				Relay:    c.RelayProtocol,
+				URL:      c.URL,
+			}
+		}
+	}
+
+	stats := Stats{Time: time.Now()}
+	for _, s := range report {
+		switch v := s.(type) {
+		case webrtc.ICECandidatePairStats:
+			stats.Pairs = append(stats.Pairs, PairStats{
+				ID:              v.ID,
+				State:           string(v.State),
+				Nominated:       v.Nominated,
+				Local:           candidates[v.LocalCandidateID],
+				Remote:          candidates[v.RemoteCandidateID],
+				RTT:             v.CurrentRoundTripTime,
+				BytesSent:       v.BytesSent,
+				BytesReceived:   v.BytesReceived,
+				PacketsSent:     v.PacketsSent,
+				PacketsReceived: v.PacketsReceived,
+				Requests:        v.RequestsSent,
+				Responses:       v.ResponsesReceived,
+			})
+		case webrtc.TransportStats:
+			stats.Transport = TransportStats{BytesSent: v.BytesSent, BytesReceived: v.BytesReceived}
+		case webrtc.DataChannelStats:
+			stats.Channels = append(stats.Channels, ChannelStats{
+				Label:            v.Label,
+				State:            v.State.String(),
+				MessagesSent:     v.MessagesSent,
+				MessagesReceived: v.MessagesReceived,
+				BytesSent:        v.BytesSent,
+				BytesReceived:    v.BytesReceived,
+			})
+		case webrtc.InboundRTPStreamStats:
+			stats.Inbound = append(stats.Inbound, InboundStats{
+				SSRC:            uint32(v.SSRC),
+				Kind:            v.Kind,
+				PacketsReceived: v.PacketsReceived,
+				PacketsLost:     v.PacketsLost,
+				BytesReceived:   v.BytesReceived,
+				Jitter:          v.Jitter,
+				NACKs:           v.NACKCount,
+				PLIs:            v.PLICount,
+			})
+		case webrtc.OutboundRTPStreamStats:
+			stats.Outbound = append(stats.Outbound, OutboundStats{
+				SSRC:        uint32(v.SSRC),
+				Kind:        v.Kind,
+				PacketsSent: v.PacketsSent,
+				BytesSent:   v.BytesSent,
+				NACKs:       v.NACKCount,
+				PLIs:        v.PLICount,
+			})
+		case webrtc.SCTPTransportStats:
+			stats.SCTP = &SCTPStats{
+				RTT:              v.SmoothedRoundTripTime,
+				CongestionWindow: v.CongestionWindow,
+				ReceiverWindow:   v.ReceiverWindow,
+				MTU:              v.MTU,
+				UnackData:        v.UNACKData,
+				BytesSent:        v.BytesSent,
+				BytesReceived:    v.BytesReceived,
+			}
+		}
+	}
+	sort.Slice(stats.Pairs, func(i, j int) bool { return stats.Pairs[i].ID < stats.Pairs[j].ID })
+	sort.Slice(stats.Channels, func(i, j int) bool { return stats.Channels[i].Label < stats.Channels[j].Label })
+
+	if sctp := p.conn.SCTP(); sctp != nil {
+		if selected, ok := sctp.Transport().ICETransport().GetSelectedCandidatePairStats(); ok {
+			for i := range stats.Pairs {
+				if stats.Pairs[i].ID == selected.ID {
+					stats.Selected = &stats.Pairs[i]
+					break
+				}
+			}
+		}
+	}
+	return stats
+}
+
+// SampleStats sends stats snapshots into the fn callback
+// every interval until the done channel is closed.
+func (p *Peer) SampleStats(interval time.Duration, done <-chan struct{}, fn func(Stats)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if p.conn.ConnectionState() == webrtc.PeerConnectionStateClosed {
+					return
+				}
+				fn(p.Stats())
+			}
+		}
+	}()
+}
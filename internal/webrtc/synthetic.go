@@ -0,0 +1,90 @@
+package webrtc
+
+import (
+	"image"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	pmedia "github.com/pion/webrtc/v4/pkg/media"
+	"github.com/sergystepanov/webrtc-troubleshooting/v2/internal/media"
+)
+
+const (
+	// the synthetic video size and frame rate
+	syntheticWidth  = 640
+	syntheticHeight = 480
+	syntheticFPS    = 15
+	// syntheticPacketTime is the audio duration of a packet
+	syntheticPacketTime = 20 * time.Millisecond
+)
+
+// Synthetic sends the generated test media until done: a VP8 test pattern
+// with the frame number and a PCMU beep every second.
+// The tracks need a renegotiation after the first one, see OnRenegotiation.
+func (p *Peer) Synthetic(done <-chan struct{}, log func(format string, v ...any)) error {
+	video, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "synthetic-video", "synthetic")
+	if err != nil {
+		return err
+	}
+	audio, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU}, "synthetic-audio", "synthetic")
+	if err != nil {
+		return err
+	}
+	for _, track := range []*webrtc.TrackLocalStaticSample{video, audio} {
+		sender, err := p.conn.AddTrack(track)
+		if err != nil {
+			return err
+		}
+		go drainRTCP(sender)
+	}
+	log("synthetic %vx%v@%v VP8 video and PCMU audio", syntheticWidth, syntheticHeight, syntheticFPS)
+
+	go func() {
+		enc := media.NewVP8(syntheticWidth, syntheticHeight)
+		img := image.NewYCbCr(image.Rect(0, 0, syntheticWidth, syntheticHeight), image.YCbCrSubsampleRatio420)
+		interval := time.Second / syntheticFPS
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			media.Pattern(img, frame)
+			if err := video.WriteSample(pmedia.Sample{Data: enc.Encode(img), Duration: interval}); err != nil {
+				log("synthetic video err: %v", err)
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	go func() {
+		var beep media.Beep
+		ticker := time.NewTicker(syntheticPacketTime)
+		defer ticker.Stop()
+		for {
+			if err := audio.WriteSample(pmedia.Sample{Data: beep.Next(syntheticPacketTime), Duration: syntheticPacketTime}); err != nil {
+				log("synthetic audio err: %v", err)
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// drainRTCP reads the RTCP of the sender for the interceptors to work.
+func drainRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package webrtc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// ThroughputLabel is the label of the test data channel.
+	ThroughputLabel = "throughput"
+	// throughputChunk is the message size which all browsers can take.
+	throughputChunk = 16 * 1024
+	// throughputHigh is the buffered amount at which the sender waits.
+	throughputHigh = 1024 * 1024
+	// throughputLow is the buffered amount at which the sender continues.
+	throughputLow = 256 * 1024
+	// throughputEnd is the text message that ends the data of a side.
+	throughputEnd = "end"
+	// throughputDrain is the extra time to wait for the other side data.
+	throughputDrain = 10 * time.Second
+)
+
+// ThroughputOptions is a throughput test request from the other side.
+type ThroughputOptions struct {
+	Duration time.Duration
+	// Send makes this peer saturate the channel
+	Send bool
+	// Receive waits for the other side data
+	Receive bool
+}
+
+// ThroughputResult is one direction of the test.
+type ThroughputResult struct {
+	// Send is true for the data sent by this peer
+	Send     bool
+	Bytes    uint64
+	Duration time.Duration
+	// Stalls is how many times the sender waited for the buffer to drain,
+	// for how long in total and the max buffered amount
+	Stalls      int
+	Waited      time.Duration
+	MaxBuffered uint64
+	// FastRetransmits and T3Timeouts are the SCTP retransmissions during the test
+	FastRetransmits uint64
+	T3Timeouts      uint64
+}
+
+// Mbps is the goodput in megabits per second.
+func (r ThroughputResult) Mbps() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / r.Duration.Seconds() / 1e6
+}
+
+func (r ThroughputResult) String() string {
+	if r.Send {
+		return fmt.Sprintf("sent %d KiB in %v (%.2f Mbit/s queued), stalls: %d for %v, max buffered %d KiB, "+
+			"fast retransmits: %d, T3 timeouts: %d", r.Bytes/1024, r.Duration.Round(time.Millisecond), r.Mbps(),
+			r.Stalls, r.Waited.Round(time.Millisecond), r.MaxBuffered/1024, r.FastRetransmits, r.T3Timeouts)
+	}
+	if r.Bytes == 0 {
+		return "received nothing"
+	}
+	return fmt.Sprintf("received %d KiB in %v, goodput %.2f Mbit/s",
+		r.Bytes/1024, r.Duration.Round(time.Millisecond), r.Mbps())
+}
+
+// Throughput opens the test data channel and runs the test
+// in the requested directions, every result goes into fn.
+// Each side sends binary messages followed by the "end" text message
+// and the side that has asked for the test closes the channel at the end.
+func (p *Peer) Throughput(opts ThroughputOptions, fn func(ThroughputResult)) error {
+	ordered := true
+	ch, err := p.conn.CreateDataChannel(ThroughputLabel, &webrtc.DataChannelInit{Ordered: &ordered})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ch.Close() }()
+
+	var wg sync.WaitGroup
+	if opts.Receive {
+		wg.Add(1)
+		received := make(chan ThroughputResult, 1)
+		var r ThroughputResult
+		var first, last time.Time
+		ch.OnMessage(func(m webrtc.DataChannelMessage) {
+			if m.IsString {
+				if string(m.Data) == throughputEnd {
+					r.Duration = last.Sub(first)
+					// only the first end counts, the callback must not block the SCTP reads
+					select {
+					case received <- r:
+					default:
+					}
+				}
+				return
+			}
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			last = now
+			r.Bytes += uint64(len(m.Data))
+		})
+		go func() {
+			defer wg.Done()
+			select {
+			case r := <-received:
+				fn(r)
+			case <-time.After(opts.Duration + throughputDrain):
+				fn(ThroughputResult{})
+			}
+		}()
+	}
+
+	opened, closed := make(chan struct{}), make(chan struct{})
+	ch.OnOpen(func() { close(opened) })
+	ch.OnClose(func() { close(closed) })
+	select {
+	case <-opened:
+	case <-time.After(throughputDrain):
+		return fmt.Errorf("the %v channel is not open", ThroughputLabel)
+	}
+
+	if opts.Send {
+		fn(p.saturate(ch, opts.Duration))
+	}
+	wg.Wait()
+	// the other side closes the channel when it has all the data
+	select {
+	case <-closed:
+	case <-time.After(throughputDrain):
+	}
+	return nil
+}
+
+// saturate sends as much as the flow control lets through for the duration.
+func (p *Peer) saturate(ch *webrtc.DataChannel, d time.Duration) ThroughputResult {
+	r := ThroughputResult{Send: true}
+	fast, t3 := p.conn.rtx.fast.Load(), p.conn.rtx.t3.Load()
+
+	low := make(chan struct{}, 1)
+	ch.SetBufferedAmountLowThreshold(throughputLow)
+	ch.OnBufferedAmountLow(func() {
+		select {
+		case low <- struct{}{}:
+		default:
+		}
+	})
+
+	data := make([]byte, throughputChunk)
+	_, _ = rand.Read(data)
+
+	start := time.Now()
+	deadline := time.NewTimer(d)
+	defer deadline.Stop()
+loop:
+	for {
+		select {
+		case <-deadline.C:
+			break loop
+		default:
+		}
+		if buffered := ch.BufferedAmount(); buffered > throughputHigh {
+			r.Stalls++
+			wait := time.Now()
+			select {
+			case <-low:
+			case <-deadline.C:
+				r.Waited += time.Since(wait)
+				break loop
+			}
+			r.Waited += time.Since(wait)
+			continue
+		}
+		if err := ch.Send(data); err != nil {
+			break
+		}
+		r.Bytes += throughputChunk
+		r.MaxBuffered = max(r.MaxBuffered, ch.BufferedAmount())
+	}
+	r.Duration = time.Since(start)
+	_ = ch.SendText(throughputEnd)
+
+	r.FastRetransmits = p.conn.rtx.fast.Load() - fast
+	r.T3Timeouts = p.conn.rtx.t3.Load() - t3
+	return r
+}
@@ -0,0 +1,47 @@
+package webrtc
+
+import (
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// trackSink starts consuming an incoming track, it returns the packet writer
+// and the function called at the end of the track, or nil to skip the track.
+type trackSink func(remote *webrtc.TrackRemote) (write func(pkt *rtp.Packet), end func())
+
+// onTrack adds a consumer of the incoming tracks,
+// where all of them get the same packets.
+// The sinks should be added before the first negotiation.
+func (p *Peer) onTrack(sink trackSink) {
+	if len(p.sinks) == 0 {
+		p.conn.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { p.readTrack(remote) })
+	}
+	p.sinks = append(p.sinks, sink)
+}
+
+// readTrack reads the track until it ends and passes each packet to the sinks.
+func (p *Peer) readTrack(remote *webrtc.TrackRemote) {
+	var writers []func(pkt *rtp.Packet)
+	for _, sink := range p.sinks {
+		write, end := sink(remote)
+		if write == nil {
+			continue
+		}
+		writers = append(writers, write)
+		if end != nil {
+			defer end()
+		}
+	}
+	if len(writers) == 0 {
+		return
+	}
+	for {
+		pkt, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		for _, write := range writers {
+			write(pkt)
+		}
+	}
+}
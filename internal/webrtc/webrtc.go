@@ -1,10 +1,12 @@
 package webrtc
 
 import (
+	"encoding/json"
 	"errors"
-	"strconv"
+	"fmt"
+	"strings"
 
-	"github.com/pion/logging"
+	"github.com/pion/stun"
 	"github.com/pion/webrtc/v4"
 )
 
@@ -14,19 +16,35 @@ type (
 	}
 	Peer struct {
 		conn *Connection
+		// sinks consume the incoming tracks
+		sinks []trackSink
 	}
 	State interface {
 		~int | ~int32 | ~uint32
 		String() string
 	}
 	ICECandidate        = webrtc.ICECandidate
+	ICECandidatePair    = webrtc.ICECandidatePair
+	ICEServer           = webrtc.ICEServer
 	ICEConnectionState  = webrtc.ICEConnectionState
 	ICEGatheringState   = webrtc.ICEGatheringState
 	PeerConnectionState = webrtc.PeerConnectionState
 	SignalingState      = webrtc.SignalingState
+	SessionDescription  = webrtc.SessionDescription
+	DataChannelInit     = webrtc.DataChannelInit
 )
 
+const (
+	ICEProtocolTCP            = webrtc.ICEProtocolTCP
+	PeerConnectionStateFailed = webrtc.PeerConnectionStateFailed
+	PeerConnectionStateClosed = webrtc.PeerConnectionStateClosed
+)
+
+func (dc *DataChannel) Label() string    { return dc.ch.Label() }
 func (dc *DataChannel) OnOpen(fn func()) { dc.ch.OnOpen(fn) }
+func (dc *DataChannel) OnMessage(fn func(data []byte)) {
+	dc.ch.OnMessage(func(m webrtc.DataChannelMessage) { fn(m.Data) })
+}
 func (dc *DataChannel) SendText(text string) error {
 	if dc.ch.ReadyState() != webrtc.DataChannelStateOpen {
 		return nil
@@ -34,40 +52,57 @@ func (dc *DataChannel) SendText(text string) error {
 	return dc.ch.SendText(text)
 }
 
-func NewPeerConnection(iceServers []string, disableInterceptors bool, port, nat1to1 string, noMDNS bool, logger logging.LoggerFactory) (*Peer, error) {
-	conf := Config{
-		DisableDefaultInterceptors: disableInterceptors,
-		DisableMDNS:                noMDNS,
-		Nat1to1:                    nat1to1,
-		Logger:                     logger,
-	}
-	if len(iceServers) > 0 {
-		var ices []webrtc.ICEServer
-		for _, s := range iceServers {
-			if s == "" {
-				continue
+// ParseICEServers reads ICE servers either as a JSON list of
+// RTCIceServer objects (urls, username, credential, credentialType)
+// or as comma-separated URLs.
+func ParseICEServers(v string) ([]ICEServer, error) {
+	v = strings.TrimSpace(v)
+	var ices []ICEServer
+	if strings.HasPrefix(v, "[") {
+		if err := json.Unmarshal([]byte(v), &ices); err != nil {
+			return nil, fmt.Errorf("bad ICE servers, %w", err)
+		}
+	} else {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ices = append(ices, ICEServer{URLs: []string{s}})
 			}
-			ices = append(ices, webrtc.ICEServer{URLs: []string{s}})
 		}
-		conf.IceServers = ices
 	}
-	if port != "" {
-		if p, err := strconv.Atoi(port); err == nil {
-			conf.SinglePort = p
+	for _, s := range ices {
+		for _, u := range s.URLs {
+			uri, err := stun.ParseURI(u)
+			if err != nil {
+				return nil, fmt.Errorf("bad ICE server URL [%v], %w", u, err)
+			}
+			if uri.Scheme == stun.SchemeTypeTURN || uri.Scheme == stun.SchemeTypeTURNS {
+				if s.Username == "" || s.Credential == nil {
+					return nil, fmt.Errorf("TURN server [%v] needs a username and credential", u)
+				}
+			}
 		}
 	}
+	return ices, nil
+}
+
+func NewPeerConnection(conf Config) (*Peer, error) {
 	conn, err := DefaultConnection(conf)
 	if err != nil {
 		return nil, err
 	}
 	if err = conn.Connect(); err != nil {
+		// the session muxes are listening already
+		_ = conn.Close()
 		return nil, err
 	}
-	return &Peer{conn}, nil
+	return &Peer{conn: conn}, nil
 }
 
-func (p *Peer) CreateDataChannel(name string) (*DataChannel, error) {
-	ch, err := p.conn.CreateDataChannel(name, nil)
+// Config returns the effective configuration of the peer.
+func (p *Peer) Config() Config { return p.conn.conf }
+
+func (p *Peer) CreateDataChannel(name string, init *DataChannelInit) (*DataChannel, error) {
+	ch, err := p.conn.CreateDataChannel(name, init)
 	if err != nil {
 		return nil, err
 	}
@@ -89,12 +124,27 @@ func (p *Peer) OnIceGatheringStateChange(fn func(state ICEGatheringState)) {
 func (p *Peer) OnSignalingStateChange(fn func(state SignalingState)) {
 	p.conn.OnSignalingStateChange(fn)
 }
+func (p *Peer) OnSelectedCandidatePairChange(fn func(pair *ICECandidatePair)) {
+	p.conn.SCTP().Transport().ICETransport().OnSelectedCandidatePairChange(fn)
+}
 func (p *Peer) OnDataChannel(fn func(d *DataChannel)) {
 	p.conn.OnDataChannel(func(channel *webrtc.DataChannel) {
 		fn(&DataChannel{channel})
 	})
 }
 
+// OnRenegotiation calls offer to send a new offer when the local tracks change
+// after the first negotiation and the connection is stable.
+func (p *Peer) OnRenegotiation(offer func()) {
+	p.conn.OnNegotiationNeeded(func() {
+		// the first offer/answer is made by the signaling
+		if p.conn.RemoteDescription() == nil {
+			return
+		}
+		offer()
+	})
+}
+
 func (p *Peer) CreateAnswer() (*webrtc.SessionDescription, error) {
 	answer, err := p.conn.CreateAnswer(nil)
 	if err != nil {
@@ -124,6 +174,15 @@ func (p *Peer) AddIceCandidate(candidate webrtc.ICECandidateInit) error {
 	return p.conn.AddICECandidate(candidate)
 }
 
+// GatheringComplete is closed when all the local candidates are gathered,
+// it should be called before the local SDP is set.
+func (p *Peer) GatheringComplete() <-chan struct{} {
+	return webrtc.GatheringCompletePromise(p.conn.PeerConnection)
+}
+
+// LocalSDP returns the local description with the gathered candidates.
+func (p *Peer) LocalSDP() *webrtc.SessionDescription { return p.conn.LocalDescription() }
+
 func (p *Peer) Close() error {
 	return p.conn.Close()
 }